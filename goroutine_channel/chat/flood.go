@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flood policies for a client that sends faster than -line-rate or
+// -byte-rate allow, chosen with the -flood flag.
+const (
+	floodMute       = "mute"       // silence the client, longer for each offence
+	floodDrop       = "drop"       // discard the lines over the limit
+	floodDisconnect = "disconnect" // drop the client with a notice
+)
+
+// A bucket is a token bucket: it holds at most burst tokens and gains
+// rate tokens a second. A rate of 0 never runs out.
+type bucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+func newBucket(rate, burst float64) bucket {
+	return bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take removes n tokens, or reports false, taking none, if there are
+// fewer than n.
+func (b *bucket) take(n float64, now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// A limiter applies the flood policy to the lines of one client. It
+// belongs to the client's handleConn goroutine, so it needs no locks,
+// but the count of offences is kept for the client's host, so that a
+// reconnecting flooder is muted for longer rather than starting over.
+type limiter struct {
+	lines, bytes bucket
+	host         string
+	mutedUntil   time.Time
+	dropped      int // lines discarded in the current run over the limit
+}
+
+func newLimiter(host string) *limiter {
+	return &limiter{
+		lines: newBucket(*lineRate, float64(*lineBurst)),
+		bytes: newBucket(*byteRate, float64(*byteBurst)),
+		host:  host,
+	}
+}
+
+// An offences is the flooding record of one host. Only the mute of the
+// offending connection depends on it: other users behind the same
+// address, such as everyone on a shared NAT, are not silenced.
+type offences struct {
+	strikes    int // offences since the host last behaved for -mute-max
+	lastStrike time.Time
+}
+
+// floods holds the offences of every host that flooded in the last
+// -mute-max. The handleConn goroutines all use it, so it has a mutex.
+var floods = struct {
+	sync.Mutex
+	byHost map[string]*offences
+}{byHost: make(map[string]*offences)}
+
+// strike records a flooding offence by host at now. It returns the
+// length of the mute it earns, doubled for every earlier offence, and
+// the number of offences.
+func strike(host string, now time.Time) (time.Duration, int) {
+	floods.Lock()
+	defer floods.Unlock()
+	for h, o := range floods.byHost {
+		if now.Sub(o.lastStrike) > *muteMax {
+			delete(floods.byHost, h) // forgiven
+		}
+	}
+	o := floods.byHost[host]
+	if o == nil {
+		o = new(offences)
+		floods.byHost[host] = o
+	}
+	o.strikes++
+	o.lastStrike = now
+	d := *muteFor
+	for i := 1; i < o.strikes && d < *muteMax; i++ {
+		d *= 2
+	}
+	if d > *muteMax {
+		d = *muteMax
+	}
+	return d, o.strikes
+}
+
+// admit reports whether line from cli may go on to be handled, telling
+// the client and logging when it may not. quit reports that the client
+// is to be disconnected.
+func (l *limiter) admit(cli *client, line string) (ok, quit bool) {
+	now := time.Now()
+	if now.Before(l.mutedUntil) && !unmuted(line) {
+		return false, false
+	}
+	if l.lines.take(1, now) && l.bytes.take(float64(len(line)), now) {
+		l.dropped = 0
+		return true, false
+	}
+	who := fmt.Sprintf("%s (%s)", cli.name, cli.conn.RemoteAddr())
+	switch *floodPolicy {
+	case floodDisconnect:
+		log.Printf("%s: flooding, disconnected", who)
+		cli.ch <- systemFrame("Disconnected: you are sending too fast")
+		return false, true
+	case floodDrop:
+		l.dropped++
+		if l.dropped == 1 || l.dropped%100 == 0 {
+			log.Printf("%s: flooding, %d lines dropped so far", who, l.dropped)
+		}
+		if l.dropped == 1 {
+			cli.ch <- systemFrame("You are sending too fast; lines are being dropped")
+		}
+		return false, false
+	}
+	d, strikes := strike(l.host, now)
+	l.mutedUntil = now.Add(d)
+	log.Printf("%s: flooding, muted for %s (offence %d)", who, d, strikes)
+	cli.ch <- systemFrame(fmt.Sprintf("You are sending too fast and are muted for %s", d))
+	return false, false
+}
+
+// unmuted reports whether line is a command a muted client may still
+// use. Nothing it allows reaches other clients.
+func unmuted(line string) bool {
+	cmd := line
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd = line[:i]
+	}
+	return cmd == "/quit" || cmd == "/help"
+}
+
+// scanLines splits like bufio.ScanLines, but fails with bufio.ErrTooLong
+// on a line longer than max bytes without its line ending, whether that
+// is LF or CR LF.
+func scanLines(max int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if len(token) > max {
+			return 0, nil, bufio.ErrTooLong
+		}
+		return advance, token, err
+	}
+}
+
+// checkTooLong tells cli, and logs, if in stopped at a line longer than
+// -max-line.
+func checkTooLong(cli *client, in input) {
+	if in.Err() != bufio.ErrTooLong {
+		return
+	}
+	log.Printf("%s (%s): line longer than %d bytes, disconnected", cli.name, cli.conn.RemoteAddr(), *maxLine)
+	cli.ch <- systemFrame(fmt.Sprintf("Disconnected: lines must be at most %d bytes", *maxLine))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string // nil if the last line is too long
+	}{
+		{"abcd\n", []string{"abcd"}},
+		{"abcd\r\n", []string{"abcd"}},
+		{"ab\r\nabcd\r\nx", []string{"ab", "abcd", "x"}},
+		{"abcde\n", nil},
+		{"abcde\r\n", nil},
+		{"ab\nabcdef", nil},
+	}
+	for _, test := range tests {
+		in := bufio.NewScanner(strings.NewReader(test.in))
+		in.Buffer(nil, 4+2)
+		in.Split(scanLines(4))
+		var got []string
+		for in.Scan() {
+			got = append(got, in.Text())
+		}
+		if tooLong := in.Err() == bufio.ErrTooLong; tooLong != (test.want == nil) {
+			t.Errorf("%q: error %v", test.in, in.Err())
+			continue
+		}
+		if test.want != nil && strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%q: got lines %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+// TestFloodByHost checks that a flooder is muted on its own connection
+// only, while a reconnect does not start its escalation over.
+func TestFloodByHost(t *testing.T) {
+	const host = "192.0.2.1"
+	defer func() {
+		floods.Lock()
+		delete(floods.byHost, host)
+		floods.Unlock()
+	}()
+	server, peer := net.Pipe()
+	defer peer.Close()
+	defer server.Close()
+	cli := &client{name: "flooder", conn: server, ch: make(chan frame, 10)}
+	// Each connection may send one line, then floods with the next.
+	dial := func() *limiter {
+		return &limiter{lines: newBucket(0.001, 1), bytes: newBucket(0, 0), host: host}
+	}
+	flood := func(l *limiter, want time.Duration) {
+		t.Helper()
+		if ok, _ := l.admit(cli, "hi"); !ok {
+			t.Fatal("first line refused")
+		}
+		if ok, _ := l.admit(cli, "hi"); ok {
+			t.Fatal("line over the limit admitted")
+		}
+		if got := (<-cli.ch).Text; !strings.HasSuffix(got, "muted for "+want.String()) {
+			t.Errorf("got notice %q, want a mute of %s", got, want)
+		}
+	}
+
+	first := dial()
+	flood(first, *muteFor)
+	first.lines = newBucket(0, 0) // the mute, not the rate, must refuse now
+	if ok, _ := first.admit(cli, "hi"); ok {
+		t.Error("muted connection may still talk")
+	}
+	if ok, _ := first.admit(cli, "/quit"); !ok {
+		t.Error("muted connection may not /quit")
+	}
+	if ok, _ := dial().admit(cli, "hi"); !ok {
+		t.Error("another connection from the same host is muted too")
+	}
+	flood(dial(), 2**muteFor)
+}
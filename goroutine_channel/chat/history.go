@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An entry is one chat line as stored in the history log.
+type entry struct {
+	Time time.Time `json:"time"`
+	Room string    `json:"room"`
+	From string    `json:"from"`
+	Text string    `json:"text"`
+}
+
+func (e entry) String() string {
+	return fmt.Sprintf("[%s] %s: %s", e.Time.Local().Format("2006-01-02 15:04"), e.From, e.Text)
+}
+
+// A historyQuery asks for the last n entries of a room, or, if term is
+// set, for the last n entries that contain it. handleConn sends it to
+// the broadcaster, which fills in room from cli and passes it on to the
+// historian.
+type historyQuery struct {
+	cli   *client
+	room  string
+	n     int
+	term  string
+	reply chan<- []entry
+}
+
+var (
+	records     = make(chan entry, 256) // entries to append to the log
+	histories   = make(chan historyQuery)
+	historyReqs = make(chan historyQuery, 16) // queries waiting for the historian
+)
+
+// A historyLog stores entries as JSON lines in numbered segment files
+// in dir: 000001.log, 000002.log, and so on. Appends go to the newest
+// segment; once it reaches segmentSize bytes a new one is started and
+// the oldest are removed so that at most keep segments remain.
+type historyLog struct {
+	dir         string
+	segmentSize int64
+	keep        int
+	segments    []int // segment numbers, oldest first
+	cur         *os.File
+	curSize     int64
+}
+
+func openHistory(dir string, segmentSize int64, keep int) (*historyLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	l := &historyLog{dir: dir, segmentSize: segmentSize, keep: keep}
+	for _, name := range names {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(name), "%06d.log", &n); err == nil {
+			l.segments = append(l.segments, n)
+		}
+	}
+	sort.Ints(l.segments)
+	if len(l.segments) == 0 {
+		return l, l.rotate()
+	}
+	last := l.segments[len(l.segments)-1]
+	l.cur, err = os.OpenFile(l.path(last), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := l.cur.Stat()
+	if err != nil {
+		return nil, err
+	}
+	l.curSize = fi.Size()
+	// A crash may have torn the last line. It is skipped when reading,
+	// but new lines must not be glued onto it.
+	if l.curSize > 0 {
+		var b [1]byte
+		if _, err := l.cur.ReadAt(b[:], l.curSize-1); err != nil {
+			return nil, err
+		}
+		if b[0] != '\n' {
+			if _, err := l.cur.Write([]byte{'\n'}); err != nil {
+				return nil, err
+			}
+			l.curSize++
+		}
+	}
+	return l, nil
+}
+
+func (l *historyLog) path(n int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%06d.log", n))
+}
+
+// rotate starts a new segment and removes the oldest beyond l.keep.
+func (l *historyLog) rotate() error {
+	if l.cur != nil {
+		l.cur.Close()
+	}
+	next := 1
+	if len(l.segments) > 0 {
+		next = l.segments[len(l.segments)-1] + 1
+	}
+	f, err := os.OpenFile(l.path(next), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.cur, l.curSize = f, 0
+	l.segments = append(l.segments, next)
+	for len(l.segments) > l.keep {
+		if err := os.Remove(l.path(l.segments[0])); err != nil {
+			log.Printf("history: %v", err)
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+func (l *historyLog) append(e entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	n, err := l.cur.Write(b)
+	l.curSize += int64(n)
+	if err != nil {
+		return err
+	}
+	if l.curSize >= l.segmentSize {
+		return l.rotate()
+	}
+	return nil
+}
+
+// scan calls f for every stored entry, oldest first. Lines that do not
+// parse, such as one torn by a crash, are skipped.
+func (l *historyLog) scan(f func(entry)) {
+	for _, n := range l.segments {
+		file, err := os.Open(l.path(n))
+		if err != nil {
+			log.Printf("history: %v", err)
+			continue
+		}
+		in := bufio.NewScanner(file)
+		in.Buffer(nil, 1<<20)
+		for in.Scan() {
+			var e entry
+			if json.Unmarshal(in.Bytes(), &e) == nil {
+				f(e)
+			}
+		}
+		file.Close()
+	}
+}
+
+// last returns the last n entries of room that contain term, which
+// matches everything if empty. Matching ignores case.
+func (l *historyLog) last(room string, n int, term string) []entry {
+	term = strings.ToLower(term)
+	var found []entry
+	l.scan(func(e entry) {
+		if e.Room != room || term != "" && !strings.Contains(strings.ToLower(e.Text), term) {
+			return
+		}
+		found = append(found, e)
+		if len(found) > 2*n {
+			found = append(found[:0], found[len(found)-n:]...)
+		}
+	})
+	if len(found) > n {
+		found = found[len(found)-n:]
+	}
+	return found
+}
+
+// recentByRoom returns the last n entries of every room, to seed the
+// replay buffers after a restart.
+func (l *historyLog) recentByRoom(n int) map[string][]entry {
+	recent := make(map[string][]entry)
+	l.scan(func(e entry) {
+		recent[e.Room] = keepLast(append(recent[e.Room], e), n)
+	})
+	return recent
+}
+
+// keepLast trims entries to its last n elements.
+func keepLast(entries []entry, n int) []entry {
+	if len(entries) > n {
+		entries = append(entries[:0], entries[len(entries)-n:]...)
+	}
+	return entries
+}
+
+// historian owns the history log. It appends what the broadcaster
+// records and answers /history and /search queries, so that slow disk
+// scans never hold up the broadcaster.
+func historian(l *historyLog) {
+	for {
+		select {
+		case e := <-records:
+			if err := l.append(e); err != nil {
+				log.Printf("history: %v", err)
+			}
+		case q := <-historyReqs:
+			q.reply <- l.last(q.room, q.n, q.term)
+		}
+	}
+}
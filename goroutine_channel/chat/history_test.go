@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHistoryBusy checks that queries beyond what the historian has
+// room for are turned away instead of piling up.
+func TestHistoryBusy(t *testing.T) {
+	h := newHub(nil, true) // no historian runs, so nothing drains the queue
+	defer func() {
+		for len(historyReqs) > 0 {
+			<-historyReqs
+		}
+	}()
+	ask := func() (*client, chan []entry) {
+		cli := &client{name: "reader", room: lobby, ch: make(chan frame, 1)}
+		reply := make(chan []entry, 1)
+		h.history(historyQuery{cli: cli, n: 10, reply: reply})
+		return cli, reply
+	}
+	for i := 0; i < cap(historyReqs); i++ {
+		if cli, _ := ask(); len(cli.ch) > 0 {
+			t.Fatalf("query %d turned away: %s", i, (<-cli.ch).Text)
+		}
+	}
+	cli, reply := ask()
+	if _, ok := <-reply; ok {
+		t.Error("reply to a query beyond the queue was not closed")
+	}
+	if len(cli.ch) == 0 || (<-cli.ch).Type != frameError {
+		t.Error("client turned away without an error")
+	}
+}
+
+// TestHistoryBehind checks that a historian busy scanning never holds
+// up the broadcaster: entries it has no room for go unlogged.
+func TestHistoryBehind(t *testing.T) {
+	h := newHub(nil, true) // no historian runs, so records fills up
+	defer func() {
+		for len(records) > 0 {
+			<-records
+		}
+	}()
+	cli := &client{name: "talker", room: lobby, ch: make(chan frame, 1)}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < cap(records)+10; i++ {
+			h.say(cli, "hello")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("say blocked on a full history log")
+	}
+	if h.skipped != 10 {
+		t.Errorf("%d entries skipped, want 10", h.skipped)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lobby is the room every client starts in and returns to on /leave.
+// It always exists; other rooms are created on the first /join and
+// removed when their last member goes.
+const lobby = "lobby"
+
+// A hub is the broadcaster's view of who is connected and where.
+// Only the broadcaster goroutine uses it, so it needs no locks.
+type hub struct {
+	clients map[string]*client          // all connected clients, by nickname
+	rooms   map[string]map[*client]bool // members of each room
+	recent  map[string][]entry          // last -replay entries of each room
+	topics  map[string]string           // set by operators with /topic
+	mutes   map[string]time.Time        // end of each operator /mute, by nickname
+	logged  bool                        // whether entries go to the historian
+	skipped int                         // entries not logged because the historian was behind
+}
+
+func newHub(recent map[string][]entry, logged bool) *hub {
+	if recent == nil {
+		recent = make(map[string][]entry)
+	}
+	return &hub{
+		clients: make(map[string]*client),
+		rooms:   map[string]map[*client]bool{lobby: {}},
+		recent:  recent,
+		topics:  make(map[string]string),
+		mutes:   make(map[string]time.Time),
+		logged:  logged,
+	}
+}
+
+// say broadcasts a chat line from cli to its room and records it.
+func (h *hub) say(cli *client, text string) {
+	if h.muted(cli) {
+		return
+	}
+	e := entry{Time: time.Now(), Room: cli.room, From: cli.name, Text: text}
+	h.recent[e.Room] = keepLast(append(h.recent[e.Room], e), *replay)
+	if h.logged {
+		// The historian may be busy scanning for a query; rather than
+		// wait for it, the log goes without the entry.
+		select {
+		case records <- e:
+		default:
+			h.skipped++
+			if h.skipped == 1 || h.skipped%100 == 0 {
+				log.Printf("history: log falling behind, %d messages not logged so far", h.skipped)
+			}
+		}
+	}
+	h.broadcast(cli.room, frame{Type: frameMessage, Time: e.Time, From: e.From, Room: e.Room, Text: text, line: cli.name + ": " + text})
+}
+
+// history answers q from the historian, or from the replay buffer if
+// there is no history log. The historian may be busy scanning, so q
+// waits in its queue rather than holding up the broadcaster; once the
+// queue is full, q is turned away and its reply channel closed.
+func (h *hub) history(q historyQuery) {
+	if q.cli.kicked {
+		q.reply <- nil
+		return
+	}
+	q.room = q.cli.room
+	if h.logged {
+		select {
+		case historyReqs <- q:
+		default:
+			send(q.cli, errorFrame("", "History is busy, try again in a moment"))
+			close(q.reply)
+		}
+		return
+	}
+	var found []entry
+	for _, e := range h.recent[q.room] {
+		if strings.Contains(strings.ToLower(e.Text), strings.ToLower(q.term)) {
+			found = append(found, e)
+		}
+	}
+	q.reply <- keepLast(found, q.n)
+}
+
+// broadcast sends f to every member of room. Members cut off for not
+// keeping up are removed, and the rest of the room is told.
+func (h *hub) broadcast(room string, f frame) {
+	var kicked []*client
+	for cli := range h.rooms[room] {
+		send(cli, f)
+		if cli.kicked {
+			kicked = append(kicked, cli)
+		}
+	}
+	for _, cli := range kicked {
+		delete(h.clients, cli.name)
+		h.exit(cli, presenceFrame(frameLeave, cli.name, cli.room, cli.name+" was disconnected for not keeping up"))
+	}
+}
+
+// enter puts cli in room, tells the others with notice, and tells cli
+// who is there.
+func (h *hub) enter(cli *client, room string, notice frame) {
+	members, ok := h.rooms[room]
+	if !ok {
+		members = make(map[*client]bool)
+		h.rooms[room] = members
+	}
+	h.broadcast(room, notice)
+	if len(members) == 0 {
+		send(cli, systemFrame("You are the first one in #"+room))
+	} else {
+		send(cli, systemFrame("Already in #"+room+": "+strings.Join(names(members), ", ")))
+	}
+	if t := h.topics[room]; t != "" {
+		send(cli, systemFrame("Topic of #"+room+": "+t))
+	}
+	if recent := h.recent[room]; len(recent) > 0 {
+		send(cli, systemFrame(fmt.Sprintf("--- last %d messages in #%s ---", len(recent), room)))
+		for _, e := range recent {
+			send(cli, e.frame())
+		}
+		send(cli, systemFrame("---"))
+	}
+	members[cli] = true
+	cli.room = room
+}
+
+// exit takes cli out of its room and tells the others with notice.
+func (h *hub) exit(cli *client, notice frame) {
+	room := cli.room
+	delete(h.rooms[room], cli)
+	cli.room = ""
+	if len(h.rooms[room]) == 0 && room != lobby {
+		delete(h.rooms, room)
+		delete(h.topics, room)
+		return
+	}
+	h.broadcast(room, notice)
+}
+
+// direct delivers a private message, replying to the sender with an
+// error if the recipient is not connected.
+func (h *hub) direct(d direct) {
+	if d.from.kicked || h.muted(d.from) {
+		return
+	}
+	to := d.from.replyTo
+	if d.to != "" {
+		to = h.clients[d.to]
+		if to == nil {
+			send(d.from, errorFrame("", "No such user online: "+d.to))
+			return
+		}
+	} else if to == nil {
+		send(d.from, errorFrame("", "No one has sent you a direct message yet"))
+		return
+	}
+	if h.clients[to.name] != to || to.kicked {
+		send(d.from, errorFrame("", to.name+" is offline"))
+		return
+	}
+	if to == d.from {
+		send(d.from, errorFrame("", "You cannot message yourself"))
+		return
+	}
+	to.replyTo = d.from
+	f := frame{Type: frameMessage, Time: time.Now(), From: d.from.name, To: to.name, Text: d.text}
+	f.line = "[from " + d.from.name + "] " + d.text
+	send(to, f)
+	f.line = "[to " + to.name + "] " + d.text
+	send(d.from, f)
+}
+
+// who tells cli who is in its room.
+func (h *hub) who(cli *client) {
+	members := h.rooms[cli.room]
+	var list []string
+	for _, name := range names(members) {
+		if n := h.clients[name].dropped; n > 0 {
+			name += fmt.Sprintf(" (%d dropped)", n)
+		}
+		list = append(list, name)
+	}
+	send(cli, systemFrame(fmt.Sprintf("%d in #%s (%d connected): %s",
+		len(members), cli.room, len(h.clients), strings.Join(list, ", "))))
+}
+
+// listRooms tells cli which rooms exist and how many are in each.
+func (h *hub) listRooms(cli *client) {
+	var list []string
+	for room, members := range h.rooms {
+		list = append(list, fmt.Sprintf("#%s (%d)", room, len(members)))
+	}
+	sort.Strings(list)
+	send(cli, systemFrame("Rooms: "+strings.Join(list, ", ")))
+}
+
+// names returns the sorted nicknames of members.
+func names(members map[*client]bool) []string {
+	var list []string
+	for cli := range members {
+		list = append(list, cli.name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// validRoom reports why name cannot be used as a room name, if it cannot.
+func validRoom(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("usage: /join <room>")
+	case len(name) > 32:
+		return fmt.Errorf("room name must be at most 32 bytes")
+	case strings.ContainsAny(name, " \t:#"):
+		return fmt.Errorf("room name must not contain spaces, colons or #")
+	}
+	return nil
+}
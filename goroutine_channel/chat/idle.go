@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+)
+
+// An input reads lines from a client and reports each one on activity,
+// so the idle watch can restart its countdown.
+type input struct {
+	*bufio.Scanner
+	activity chan<- struct{} // nil when idle timeouts are off
+}
+
+func (in input) Scan() bool {
+	if !in.Scanner.Scan() {
+		return false
+	}
+	if in.activity != nil {
+		in.activity <- struct{}{}
+	}
+	return true
+}
+
+// idleWatch disconnects conn once the client has sent no line for
+// timeout, warning it on out when warning is left. Every value on
+// activity restarts the countdown. idleWatch returns once activity is
+// closed, which handleConn does before it sends leaving or closes out,
+// so the watch never writes to a closed channel.
+//
+// The disconnect expires conn's read deadline rather than closing it:
+// the pending Scan in handleConn fails, and the client leaves through
+// the same path as one that hung up, while the goodbye can still be
+// written. A client that reads nothing either has a full queue, so the
+// notices are dropped rather than wait for room, and the write deadline
+// unblocks clientWriter.
+func idleWatch(conn net.Conn, out chan<- frame, activity <-chan struct{}, timeout, warning time.Duration) {
+	if warning > timeout {
+		warning = timeout
+	}
+	timer := time.NewTimer(timeout - warning)
+	warned := false
+	for {
+		select {
+		case _, ok := <-activity:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			if !ok {
+				return
+			}
+			warned = false
+			timer.Reset(timeout - warning)
+		case <-timer.C:
+			if !warned && warning > 0 {
+				warned = true
+				notify(out, systemFrame(fmt.Sprintf("You have been idle for %s and will be disconnected in %s", timeout-warning, warning)))
+				timer.Reset(warning)
+				continue
+			}
+			conn.SetReadDeadline(time.Now())
+			notify(out, systemFrame("Disconnected after "+timeout.String()+" of inactivity"))
+			conn.SetWriteDeadline(time.Now().Add(time.Second))
+			for range activity {
+				// Wait for handleConn to notice and stop us.
+			}
+			return
+		}
+	}
+}
+
+// notify queues f on out unless out is full.
+func notify(out chan<- frame, f frame) {
+	select {
+	case out <- f:
+	default:
+	}
+}
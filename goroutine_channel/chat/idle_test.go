@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestIdleStalledClient checks that a client which neither reads nor
+// writes is still disconnected when its queue is full.
+func TestIdleStalledClient(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	out := make(chan frame) // always full: nothing drains it
+	activity, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		idleWatch(server, out, activity, 50*time.Millisecond, 20*time.Millisecond)
+		close(done)
+	}()
+
+	scanned := make(chan bool)
+	go func() { scanned <- bufio.NewScanner(server).Scan() }()
+	select {
+	case ok := <-scanned:
+		if ok {
+			t.Fatal("Scan succeeded on a silent client")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle client not disconnected")
+	}
+	close(activity)
+	<-done
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Error("write to an unread client did not time out")
+	}
+}
+
+func TestIdleWarning(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	out := make(chan frame, 4)
+	activity := make(chan struct{})
+	go idleWatch(server, out, activity, 100*time.Millisecond, 50*time.Millisecond)
+	defer close(activity)
+
+	want := []string{"You have been idle for 50ms and will be disconnected in 50ms", "Disconnected after 100ms of inactivity"}
+	for _, text := range want {
+		select {
+		case f := <-out:
+			if f.Text != text {
+				t.Errorf("got notice %q, want %q", f.Text, text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notice %q", text)
+		}
+	}
+}
@@ -2,80 +2,473 @@ package main
 
 import (
 	"bufio"
+	"crypto/subtle"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-type client chan<- string // an outgoing message channel
+var (
+	idleTimeout = flag.Duration("idle", 10*time.Minute, "disconnect clients that send nothing for this long (0 disables)")
+	idleWarning = flag.Duration("idle-warning", time.Minute, "how long before an idle disconnect to warn the client")
+	queueSize   = flag.Int("queue", 64, "outbound messages buffered per client")
+	overflow    = flag.String("overflow", dropOldest, "what to do when a client's queue is full: drop-oldest or disconnect")
+	historyDir  = flag.String("history", "chatlog", "directory for the chat history log (empty disables it)")
+	replay      = flag.Int("replay", 20, "messages of a room replayed to clients entering it")
+	segmentSize = flag.Int64("segment-size", 1<<20, "size in bytes at which a history segment is rotated")
+	segments    = flag.Int("segments", 10, "history segments kept on disk")
+	httpAddr    = flag.String("http", "localhost:8080", "address for the browser client and WebSocket gateway (empty disables it)")
+	maxLine     = flag.Int("max-line", 4096, "longest line in bytes a client may send; longer ones disconnect it")
+	lineRate    = flag.Float64("line-rate", 5, "lines a second each client may send on average (0 disables the limit)")
+	lineBurst   = flag.Int("line-burst", 10, "lines a client may send at once before -line-rate applies")
+	byteRate    = flag.Float64("byte-rate", 2048, "bytes a second each client may send on average (0 disables the limit)")
+	byteBurst   = flag.Int("byte-burst", 16384, "bytes a client may send at once before -byte-rate applies")
+	floodPolicy = flag.String("flood", floodMute, "what to do with a client over the rate limits: mute, drop or disconnect")
+	muteFor     = flag.Duration("mute", 10*time.Second, "first mute for flooding; each further offence doubles it")
+	muteMax     = flag.Duration("mute-max", 10*time.Minute, "longest mute for flooding, and how long until offences are forgotten")
+	operPass    = flag.String("oper-password", os.Getenv("CHAT_OPER_PASSWORD"), "password for /oper (empty disables operators; defaults to $CHAT_OPER_PASSWORD)")
+	bansFile    = flag.String("bans", "bans.json", "file the operators' bans are kept in (empty keeps them in memory)")
+)
+
+// A client is one connected user. Its name, room, dropped, kicked and
+// oper are owned by the broadcaster, which is the only goroutine that
+// reads or changes them.
+type client struct {
+	name    string
+	room    string
+	ch      chan frame // an outgoing frame queue, drained by clientWriter
+	conn    net.Conn
+	dropped int     // frames discarded because ch was full
+	kicked  bool    // cut off by the broadcaster; leaving is still to come
+	replyTo *client // sender of the last direct message, for /r
+	oper    bool    // has given the operator password
+
+	// asJSON is set to 1 by login once the client has negotiated JSON
+	// frames. clientWriter reads it, so it is only used atomically.
+	asJSON int32
+}
+
+// A message is a line of chat from a client.
+type message struct {
+	from *client
+	text string
+}
+
+// A join asks the broadcaster to admit cli under cli.name. The
+// broadcaster replies on ok with false if the name is already taken.
+type join struct {
+	cli *client
+	ok  chan<- bool
+}
+
+// A rename asks the broadcaster to change the nickname of cli.
+type rename struct {
+	cli  *client
+	name string
+	ok   chan<- bool
+}
+
+// A direct is a private message for one client. An empty to means
+// a reply to whoever last sent from a direct message.
+type direct struct {
+	from *client
+	to   string
+	text string
+}
+
+// A move asks the broadcaster to put cli in room, creating it if needed.
+type move struct {
+	cli  *client
+	room string
+}
 
 var (
-	entering = make(chan client)
-	leaving  = make(chan client)
-	messages = make(chan string) // all incoming client messages
+	entering  = make(chan join)
+	leaving   = make(chan *client)
+	messages  = make(chan message) // all incoming client messages
+	renames   = make(chan rename)
+	whos      = make(chan *client) // clients asking who is in their room
+	moves     = make(chan move)
+	roomLists = make(chan *client) // clients asking which rooms exist
+	directs   = make(chan direct)
 )
 
-func broadcaster() {
-	clients := make(map[client]bool) // all connected clients
+func broadcaster(h *hub) {
 	for {
 		select {
 		case msg := <-messages:
-			// Broadcast incoming message to all
-			// clients' outgoing message channels.
-			for cli := range clients {
-				cli <- msg
+			// Broadcast incoming message to all clients in the
+			// sender's room through their outgoing message channels.
+			h.say(msg.from, msg.text)
+
+		case j := <-entering:
+			if _, taken := h.clients[j.cli.name]; taken {
+				j.ok <- false
+				continue
+			}
+			j.ok <- true
+			h.clients[j.cli.name] = j.cli
+			send(j.cli, systemFrame("You are "+j.cli.name+" (type /help for commands)"))
+			h.enter(j.cli, lobby, presenceFrame(frameJoin, j.cli.name, lobby, j.cli.name+" has arrived"))
+			h.muted(j.cli) // a mute outlasts the connection it was given on
+
+		case r := <-renames:
+			if _, taken := h.clients[r.name]; taken || r.cli.kicked {
+				r.ok <- false
+				continue
+			}
+			old := r.cli.name
+			delete(h.clients, old)
+			r.cli.name = r.name
+			h.clients[r.name] = r.cli
+			if until, ok := h.mutes[old]; ok {
+				h.mutes[r.name] = until // a new nickname does not lift a mute
+			}
+			r.ok <- true
+			h.broadcast(r.cli.room, systemFrame(old+" is now known as "+r.name))
+
+		case cli := <-whos:
+			h.who(cli)
+
+		case m := <-moves:
+			if m.cli.kicked {
+				continue
+			}
+			if m.cli.room == m.room {
+				send(m.cli, systemFrame("You are already in #"+m.room))
+				continue
 			}
-		case cli := <-entering:
-			clients[cli] = true
+			h.exit(m.cli, presenceFrame(frameLeave, m.cli.name, m.cli.room, m.cli.name+" left for #"+m.room))
+			h.enter(m.cli, m.room, presenceFrame(frameJoin, m.cli.name, m.room, m.cli.name+" has joined #"+m.room))
+
+		case cli := <-roomLists:
+			h.listRooms(cli)
+
+		case d := <-directs:
+			h.direct(d)
+
+		case q := <-histories:
+			h.history(q)
+
+		case a := <-mods:
+			h.moderate(a)
 
 		case cli := <-leaving:
-			delete(clients, cli)
-			close(cli)
+			if cli.dropped > 0 {
+				log.Printf("%s left with %d messages dropped", cli.name, cli.dropped)
+			}
+			close(cli.ch)
+			if h.clients[cli.name] != cli {
+				continue // already removed and announced
+			}
+			// A client kicked outside broadcast is still registered.
+			why := " has left"
+			if cli.kicked {
+				why = " was disconnected for not keeping up"
+			}
+			delete(h.clients, cli.name)
+			h.exit(cli, presenceFrame(frameLeave, cli.name, cli.room, cli.name+why))
 		}
 	}
 }
 
+// validNick reports why name cannot be used as a nickname, if it cannot.
+func validNick(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("nickname must not be empty")
+	case len(name) > 32:
+		return fmt.Errorf("nickname must be at most 32 bytes")
+	case strings.ContainsAny(name, " \t:"):
+		return fmt.Errorf("nickname must not contain spaces or colons")
+	case strings.HasPrefix(name, "/"):
+		return fmt.Errorf("nickname must not start with /")
+	}
+	return nil
+}
+
 func handleConn(conn net.Conn) {
-	ch := make(chan string) // outgoing client messages
-	go clientWriter(conn, ch)
+	ch := make(chan frame, *queueSize) // outgoing client frames
+	cli := &client{ch: ch, conn: conn}
+	go clientWriter(cli)
+
+	input := input{Scanner: bufio.NewScanner(conn)}
+	input.Buffer(nil, *maxLine+2) // room for CR LF
+	input.Split(scanLines(*maxLine))
+	stopIdle := func() {}
+	if *idleTimeout > 0 {
+		activity, done := make(chan struct{}), make(chan struct{})
+		input.activity = activity
+		go func() {
+			idleWatch(conn, ch, activity, *idleTimeout, *idleWarning)
+			close(done)
+		}()
+		stopIdle = func() { close(activity); <-done }
+	}
 
-	who := conn.RemoteAddr().String()
-	ch <- "You are " + who
-	messages <- who + " has arrived"
-	entering <- ch
+	asJSON, ok := login(cli, input)
+	if !ok {
+		checkTooLong(cli, input)
+		// Never admitted, so the broadcaster does not know about ch.
+		stopIdle()
+		close(ch)
+		return
+	}
 
-	input := bufio.NewScanner(conn)
-	for input.Scan() {
-		messages <- who + ": " + input.Text()
+	limit := newLimiter(hostOf(conn.RemoteAddr().String()))
+	for {
+		line, ok := next(cli, input, asJSON)
+		if !ok {
+			checkTooLong(cli, input)
+			break
+		}
+		admitted, quit := limit.admit(cli, line)
+		if quit {
+			break
+		}
+		if !admitted {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			if !command(cli, line) {
+				break
+			}
+			continue
+		}
+		messages <- message{cli, line}
 	}
-	// NOTE: ignoring potential errors from input.Err()
+	// NOTE: ignoring input.Err() other than a line over -max-line
 
 	// 由于channel被关闭 后续的读取都会立即返回 不再阻塞 这是一个重要的信号
 	// 表明发送者已经完成了数据发送 通过将某一个channel用于此特定目的
 	// 我们可以控制一个goroutine的退出
-	leaving <- ch
-	messages <- who + " has left"
-	conn.Close()
+	stopIdle()
+	leaving <- cli
 }
 
-func clientWriter(conn net.Conn, ch <-chan string) {
-	for msg := range ch {
-		fmt.Fprintln(conn, msg) // NOTE: ignoring network errors
+// login prompts for a nickname until the broadcaster accepts one. A
+// hello frame in place of the first nickname switches the client to
+// JSON frames, and may carry the nickname itself. login reports whether
+// the client speaks JSON, and false if it went away first.
+func login(cli *client, input input) (asJSON, ok bool) {
+	cli.ch <- systemFrame("Enter your nickname:")
+	for first := true; ; first = false {
+		name, ok := next(cli, input, asJSON)
+		if !ok {
+			return asJSON, false
+		}
+		if hello, isHello := parseHello(name); first && isHello {
+			v, ok := negotiate(hello)
+			if !ok {
+				cli.ch <- errorFrame(hello.ID, fmt.Sprintf("no common protocol version; this server speaks %d", protocolVersion))
+				return false, false
+			}
+			asJSON = true
+			atomic.StoreInt32(&cli.asJSON, 1)
+			cli.ch <- frame{Type: frameHello, Time: time.Now(), From: server, ID: hello.ID, Version: v, Versions: []int{protocolVersion}}
+			if hello.Nick == "" {
+				continue
+			}
+			name = hello.Nick
+		}
+		name = strings.TrimSpace(name)
+		if err := validNick(name); err != nil {
+			cli.ch <- errorFrame("", err.Error()+", try again:")
+			continue
+		}
+		cli.name = name
+		admitted := make(chan bool)
+		entering <- join{cli, admitted}
+		if <-admitted {
+			return asJSON, true
+		}
+		cli.ch <- errorFrame("", "Nickname "+name+" is taken, try another:")
+	}
+}
+
+// command runs a /command typed by cli. It reports false if the
+// client asked to quit.
+func command(cli *client, line string) bool {
+	cmd, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch cmd {
+	case "/nick":
+		if err := validNick(arg); err != nil {
+			cli.ch <- errorFrame("", err.Error())
+			break
+		}
+		ok := make(chan bool)
+		renames <- rename{cli, arg, ok}
+		if !<-ok {
+			cli.ch <- errorFrame("", "Nickname "+arg+" is taken")
+		}
+	case "/who":
+		whos <- cli
+	case "/join":
+		room := strings.TrimPrefix(arg, "#")
+		if err := validRoom(room); err != nil {
+			cli.ch <- errorFrame("", err.Error())
+			break
+		}
+		moves <- move{cli, room}
+	case "/leave":
+		moves <- move{cli, lobby}
+	case "/rooms":
+		roomLists <- cli
+	case "/history":
+		n := *replay
+		if arg != "" {
+			var err error
+			if n, err = strconv.Atoi(arg); err != nil || n <= 0 || n > 500 {
+				cli.ch <- errorFrame("", "usage: /history [n], with n from 1 to 500")
+				break
+			}
+		}
+		showHistory(cli, historyQuery{cli: cli, n: n}, "No messages yet")
+	case "/search":
+		if arg == "" {
+			cli.ch <- errorFrame("", "usage: /search <term>")
+			break
+		}
+		showHistory(cli, historyQuery{cli: cli, n: 50, term: arg}, "No messages matching "+arg)
+	case "/msg":
+		to, text := arg, ""
+		if i := strings.IndexByte(arg, ' '); i >= 0 {
+			to, text = arg[:i], strings.TrimSpace(arg[i+1:])
+		}
+		if to == "" || text == "" {
+			cli.ch <- errorFrame("", "usage: /msg <nick> <text>")
+			break
+		}
+		directs <- direct{cli, to, text}
+	case "/r":
+		if arg == "" {
+			cli.ch <- errorFrame("", "usage: /r <text>")
+			break
+		}
+		directs <- direct{cli, "", arg}
+	case "/oper":
+		switch {
+		case *operPass == "":
+			cli.ch <- errorFrame("", "This server has no operators")
+		case subtle.ConstantTimeCompare([]byte(arg), []byte(*operPass)) != 1:
+			log.Printf("%s (%s): wrong operator password", cli.name, cli.conn.RemoteAddr())
+			cli.ch <- errorFrame("", "Wrong operator password")
+		default:
+			mods <- modAction{by: cli, op: "oper"}
+		}
+	case "/kick", "/ban", "/unban", "/mute", "/unmute", "/topic":
+		a, err := parseModAction(cli, cmd[1:], arg)
+		if err != nil {
+			cli.ch <- errorFrame("", err.Error())
+			break
+		}
+		mods <- a
+	case "/quit":
+		cli.ch <- systemFrame("Bye")
+		return false
+	case "/help":
+		cli.ch <- systemFrame("Commands: /nick <name>, /who, /join <room>, /leave, /rooms, /msg <nick> <text>, /r <text>, /history [n], /search <term>, /topic [text], /oper <password>, /quit, /help; for operators: /kick, /ban, /unban, /mute, /unmute")
+	default:
+		cli.ch <- errorFrame("", "Unknown command "+cmd+" (type /help)")
 	}
+	return true
+}
+
+// showHistory runs q for cli's room and writes the entries found.
+func showHistory(cli *client, q historyQuery, none string) {
+	reply := make(chan []entry, 1)
+	q.reply = reply
+	histories <- q
+	found, ok := <-reply
+	if !ok {
+		return // turned away, and told so
+	}
+	if len(found) == 0 {
+		cli.ch <- systemFrame(none)
+		return
+	}
+	for _, e := range found {
+		cli.ch <- e.frame()
+	}
+}
+
+// clientWriter writes each frame on cli.ch to cli.conn, as text until
+// login switches the client to JSON. The switch is kept on the client
+// rather than taken from the hello frame, which a full queue may drop;
+// only the nickname prompt, queued before the hello was read, may come
+// out either way. clientWriter closes the connection once cli.ch is
+// closed, so that everything sent to the client, such as the reply to
+// /quit, is written first.
+func clientWriter(cli *client) {
+	for f := range cli.ch {
+		asJSON := atomic.LoadInt32(&cli.asJSON) == 1
+		writeFrame(cli.conn, f, asJSON) // NOTE: ignoring network errors
+	}
+	cli.conn.Close()
 }
 
 func main() {
+	flag.Parse()
+	if *overflow != dropOldest && *overflow != disconnect {
+		log.Fatalf("unknown -overflow policy %q", *overflow)
+	}
+	if *queueSize < 1 {
+		log.Fatal("-queue must be at least 1")
+	}
 	listener, err := net.Listen("tcp", "localhost:8000")
 	if err != nil {
 		log.Fatal(err)
 	}
-	go broadcaster()
+	switch *floodPolicy {
+	case floodMute, floodDrop, floodDisconnect:
+	default:
+		log.Fatalf("unknown -flood policy %q", *floodPolicy)
+	}
+	if *maxLine < 1 || *byteRate > 0 && *byteBurst < *maxLine {
+		log.Fatal("-max-line must be positive and no more than -byte-burst")
+	}
+	if bans, err = loadBans(*bansFile); err != nil {
+		log.Fatal(err)
+	}
+	if *idleTimeout > 0 && *idleWarning >= *idleTimeout {
+		log.Fatal("-idle-warning must be shorter than -idle")
+	}
+	if *segments < 1 || *replay < 0 {
+		log.Fatal("-segments must be at least 1 and -replay must not be negative")
+	}
+	var recent map[string][]entry
+	if *historyDir != "" {
+		hl, err := openHistory(*historyDir, *segmentSize, *segments)
+		if err != nil {
+			log.Fatal(err)
+		}
+		recent = hl.recentByRoom(*replay)
+		go historian(hl)
+	}
+	go broadcaster(newHub(recent, *historyDir != ""))
+	if *httpAddr != "" {
+		go serveHTTP(*httpAddr)
+	}
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			log.Print(err)
 			continue
 		}
+		if notice, banned := refuse(conn.RemoteAddr().String()); banned {
+			fmt.Fprintln(conn, notice)
+			conn.Close()
+			continue
+		}
 		go handleConn(conn)
 	}
 }
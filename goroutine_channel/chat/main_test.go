@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs one broadcaster, without a history log, for all the
+// tests, as main does for the server.
+func TestMain(m *testing.M) {
+	*overflow = disconnect
+	*idleTimeout = 0
+	*replay = 0
+	*lineRate, *byteRate = 0, 0
+	*operPass = "secret"
+	go broadcaster(newHub(nil, false))
+	os.Exit(m.Run())
+}
+
+// A testClient is the far end of a connection served by handleConn.
+type testClient struct {
+	conn  net.Conn
+	lines chan string // everything the server wrote, line by line
+}
+
+// dial connects a client that reads everything the server writes.
+func dial(t *testing.T) *testClient {
+	t.Helper()
+	server, conn := net.Pipe()
+	go handleConn(server)
+	c := &testClient{conn, make(chan string, 1000)}
+	go func() {
+		in := bufio.NewScanner(conn)
+		for in.Scan() {
+			c.lines <- in.Text()
+		}
+		close(c.lines)
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return c
+}
+
+// connect connects a client under nick.
+func connect(t *testing.T, nick string) *testClient {
+	t.Helper()
+	c := dial(t)
+	c.send(t, nick)
+	c.expect(t, "You are "+nick)
+	return c
+}
+
+func (c *testClient) send(t *testing.T, line string) {
+	t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintln(c.conn, line); err != nil {
+		t.Fatalf("sending %q: %v", line, err)
+	}
+}
+
+// expect reads lines until one contains want.
+func (c *testClient) expect(t *testing.T, want string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				t.Fatalf("connection closed waiting for %q", want)
+			}
+			if strings.Contains(line, want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operators are clients that have given the -oper-password with /oper.
+// Only they may kick, ban and mute others or set a room's topic.
+const (
+	defaultBan  = 24 * time.Hour
+	defaultMute = 5 * time.Minute
+)
+
+// A modAction is an operator command for the broadcaster, which checks
+// that by is an operator before carrying it out. op is the command
+// without its slash.
+type modAction struct {
+	by     *client
+	op     string
+	target string        // nickname, or IP address for ban and unban
+	d      time.Duration // how long a ban or mute lasts
+	text   string        // reason, or the new topic
+}
+
+var mods = make(chan modAction)
+
+// parseModAction parses the arguments of an operator command:
+// a target, then for ban and mute an optional duration, then a reason.
+func parseModAction(cli *client, op, arg string) (modAction, error) {
+	a := modAction{by: cli, op: op}
+	if op == "topic" {
+		a.text = arg
+		return a, nil
+	}
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return a, fmt.Errorf("usage: /%s <nick>%s", op, modUsage[op])
+	}
+	a.target, fields = fields[0], fields[1:]
+	switch op {
+	case "ban", "mute":
+		a.d = defaultBan
+		if op == "mute" {
+			a.d = defaultMute
+		}
+		if len(fields) > 0 {
+			if d, err := time.ParseDuration(fields[0]); err == nil && d > 0 {
+				a.d, fields = d, fields[1:]
+			}
+		}
+	}
+	a.text = strings.Join(fields, " ")
+	return a, nil
+}
+
+var modUsage = map[string]string{
+	"kick":   " [reason]",
+	"ban":    " [duration] [reason], or /ban <ip> ...",
+	"unban":  ", or /unban <ip>",
+	"mute":   " [duration] [reason]",
+	"unmute": "",
+}
+
+// moderate carries out a for the broadcaster.
+func (h *hub) moderate(a modAction) {
+	by := a.by
+	if by.kicked {
+		return
+	}
+	switch {
+	case a.op == "oper":
+		by.oper = true
+		log.Printf("%s (%s) is now an operator", by.name, by.conn.RemoteAddr())
+		send(by, systemFrame("You are now an operator"))
+		return
+	case a.op == "topic" && a.text == "":
+		if t := h.topics[by.room]; t != "" {
+			send(by, systemFrame("Topic of #"+by.room+": "+t))
+		} else {
+			send(by, systemFrame("#"+by.room+" has no topic"))
+		}
+		return
+	case !by.oper:
+		send(by, errorFrame("", "Only operators can use /"+a.op))
+		return
+	}
+
+	target := h.clients[a.target]
+	switch a.op {
+	case "kick", "mute":
+		if target == nil || target.kicked {
+			send(by, errorFrame("", "No such user online: "+a.target))
+			return
+		}
+	case "unmute":
+		if time.Now().After(h.mutes[a.target]) {
+			send(by, errorFrame("", a.target+" is not muted"))
+			return
+		}
+	}
+	reason := ""
+	if a.text != "" {
+		reason = " (" + a.text + ")"
+	}
+	what := []string{by.name + ": /" + a.op}
+	if a.target != "" {
+		what = append(what, a.target)
+	}
+	if a.d > 0 {
+		what = append(what, "for "+a.d.String())
+	}
+	if a.text != "" {
+		what = append(what, strconv.Quote(a.text))
+	}
+	log.Print(strings.Join(what, " "))
+
+	switch a.op {
+	case "kick":
+		h.expel(target, "kicked by "+by.name+reason)
+	case "ban":
+		b := ban{IP: canonicalIP(a.target), By: by.name, Reason: a.text, Expires: time.Now().Add(a.d)}
+		if target != nil {
+			b.IP, b.Nick = hostOf(target.conn.RemoteAddr().String()), target.name
+		} else if net.ParseIP(b.IP) == nil {
+			send(by, errorFrame("", "No such user online or IP address: "+a.target))
+			return
+		}
+		if err := bans.add(b); err != nil {
+			log.Printf("saving bans: %v", err)
+			send(by, errorFrame("", "Ban applied but not saved: "+err.Error()))
+		}
+		send(by, systemFrame(fmt.Sprintf("Banned %s until %s", b.IP, b.Expires.Format(time.RFC1123))))
+		for _, cli := range h.clients {
+			if cli != by && !cli.kicked && hostOf(cli.conn.RemoteAddr().String()) == b.IP {
+				h.expel(cli, "banned by "+by.name+reason)
+			}
+		}
+	case "unban":
+		ip := canonicalIP(a.target)
+		if b, ok := bans.byNick(a.target); ok {
+			ip = b.IP
+		}
+		found, err := bans.remove(ip)
+		switch {
+		case err != nil:
+			log.Printf("saving bans: %v", err)
+			send(by, errorFrame("", "Unban applied but not saved: "+err.Error()))
+		case !found:
+			send(by, errorFrame("", "No ban for "+a.target))
+		default:
+			send(by, systemFrame("Unbanned "+ip))
+		}
+	case "mute":
+		now := time.Now()
+		for nick, until := range h.mutes {
+			if now.After(until) {
+				delete(h.mutes, nick)
+			}
+		}
+		h.mutes[target.name] = now.Add(a.d)
+		send(target, systemFrame(fmt.Sprintf("You were muted for %s by %s%s", a.d, by.name, reason)))
+		send(by, systemFrame(fmt.Sprintf("Muted %s for %s", target.name, a.d)))
+	case "unmute":
+		delete(h.mutes, a.target)
+		if target != nil && !target.kicked {
+			send(target, systemFrame("You were unmuted by "+by.name))
+		}
+		send(by, systemFrame("Unmuted "+a.target))
+	case "topic":
+		h.topics[by.room] = a.text
+		h.broadcast(by.room, systemFrame(by.name+" set the topic of #"+by.room+": "+a.text))
+	}
+}
+
+// expel cuts cli off and tells its room why.
+func (h *hub) expel(cli *client, why string) {
+	kick(cli, systemFrame("You were "+why))
+	delete(h.clients, cli.name)
+	h.exit(cli, presenceFrame(frameLeave, cli.name, cli.room, cli.name+" was "+why))
+}
+
+// muted reports whether cli may not talk, telling it so if it may not.
+// Mutes are kept by nickname rather than on the client, so that leaving
+// and coming back does not lift one. They are not kept by address, as
+// bans are, because that would silence everyone behind the same one.
+func (h *hub) muted(cli *client) bool {
+	left := time.Until(h.mutes[cli.name])
+	if left <= 0 {
+		return false
+	}
+	send(cli, errorFrame("", "You are muted for another "+left.Round(time.Second).String()))
+	return true
+}
+
+// A ban keeps clients from an IP address out until it expires.
+type ban struct {
+	IP      string    `json:"ip"`
+	Nick    string    `json:"nick,omitempty"` // as given to /ban
+	By      string    `json:"by"`
+	Reason  string    `json:"reason,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// A banList is the set of bans in force, saved as JSON to path after
+// every change. The accept loops check it as the broadcaster changes
+// it, so unlike the rest of the chat's state it is guarded by a mutex.
+type banList struct {
+	mu   sync.Mutex
+	path string         // empty to keep bans in memory only
+	bans map[string]ban // by IP address, as canonicalIP gives it
+}
+
+var bans = &banList{bans: make(map[string]ban)}
+
+// loadBans reads the bans saved at path, if there are any.
+func loadBans(path string) (*banList, error) {
+	l := &banList{path: path, bans: make(map[string]ban)}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []ban
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, b := range list {
+		b.IP = canonicalIP(b.IP)
+		l.bans[b.IP] = b
+	}
+	return l, nil
+}
+
+// banned reports the ban in force against ip, if there is one.
+func (l *banList) banned(ip string) (ban, bool) {
+	ip = canonicalIP(ip)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.bans[ip]
+	if ok && time.Now().After(b.Expires) {
+		delete(l.bans, ip)
+		return ban{}, false
+	}
+	return b, ok
+}
+
+// byNick returns the ban made with /ban nick, if it is still in force.
+func (l *banList) byNick(nick string) (ban, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range l.bans {
+		if b.Nick == nick && time.Now().Before(b.Expires) {
+			return b, true
+		}
+	}
+	return ban{}, false
+}
+
+func (l *banList) add(b ban) error {
+	b.IP = canonicalIP(b.IP)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bans[b.IP] = b
+	return l.save()
+}
+
+// remove lifts the ban on ip, reporting whether there was one.
+func (l *banList) remove(ip string) (bool, error) {
+	ip = canonicalIP(ip)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.bans[ip]; !ok {
+		return false, nil
+	}
+	delete(l.bans, ip)
+	return true, l.save()
+}
+
+// save writes the unexpired bans to l.path through a temporary file,
+// so a crash never leaves it half written. l.mu must be held.
+func (l *banList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	list := []ban{}
+	for ip, b := range l.bans {
+		if time.Now().After(b.Expires) {
+			delete(l.bans, ip)
+			continue
+		}
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".bans-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// refuse reports whether the client at addr is banned, and if so logs
+// it and returns the notice to give it.
+func refuse(addr string) (string, bool) {
+	b, ok := bans.banned(hostOf(addr))
+	if !ok {
+		return "", false
+	}
+	log.Printf("%s: refused, banned until %s", addr, b.Expires.Format(time.RFC3339))
+	msg := "You are banned until " + b.Expires.Format(time.RFC1123)
+	if b.Reason != "" {
+		msg += " (" + b.Reason + ")"
+	}
+	return msg, true
+}
+
+// hostOf returns the IP address in a host:port network address.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return canonicalIP(addr)
+	}
+	return canonicalIP(host)
+}
+
+// canonicalIP returns ip in the one form bans are kept in, so that
+// "::FFFF:192.0.2.1", "::ffff:192.0.2.1" and "192.0.2.1" all match.
+// Anything that is not an IP address is returned unchanged.
+func canonicalIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBanForms checks that a ban holds whichever way its IP address is
+// written, when it is made, looked up, lifted or loaded.
+func TestBanForms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	l, err := loadBans(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	for _, ip := range []string{"::FFFF:192.0.2.1", "2001:DB8:0::1"} {
+		if err := l.add(ban{IP: ip, By: "oper", Expires: expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := bans
+	bans = l
+	defer func() { bans = saved }()
+	for _, addr := range []string{"192.0.2.1:4000", "[::ffff:192.0.2.1]:4000", "[2001:db8::1]:4000", "[2001:0db8::0001]:4000"} {
+		if _, banned := refuse(addr); !banned {
+			t.Errorf("%s not refused", addr)
+		}
+	}
+
+	l, err = loadBans(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.banned("192.0.2.1"); !ok {
+		t.Error("ban on 192.0.2.1 lost on reloading")
+	}
+	if found, err := l.remove("0:0:0:0:0:ffff:c000:201"); err != nil || !found {
+		t.Errorf("remove = %t, %v, want the ban on 192.0.2.1 lifted", found, err)
+	}
+	if _, ok := l.banned("::ffff:192.0.2.1"); ok {
+		t.Error("192.0.2.1 still banned after unban")
+	}
+
+	// A hand-edited file may use any form.
+	data := []byte(`[{"ip": "2001:DB8::2", "by": "oper", "expires": "` + expires.Format(time.RFC3339) + `"}]`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = loadBans(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.banned(hostOf("[2001:db8::2]:4000")); !ok {
+		t.Error("ban loaded as 2001:DB8::2 does not match 2001:db8::2")
+	}
+}
+
+// oper connects a client under nick and makes it an operator.
+func oper(t *testing.T, nick string) *testClient {
+	t.Helper()
+	c := connect(t, nick)
+	c.send(t, "/oper secret")
+	c.expect(t, "You are now an operator")
+	return c
+}
+
+func TestOperatorOnly(t *testing.T) {
+	user := connect(t, "pleb")
+	connect(t, "target")
+	for _, cmd := range []string{"/kick target", "/ban target", "/unban target", "/mute target", "/unmute target", "/topic hello"} {
+		user.send(t, cmd)
+		user.expect(t, "Only operators can use "+strings.Fields(cmd)[0])
+	}
+	user.send(t, "/oper guess")
+	user.expect(t, "Wrong operator password")
+	user.send(t, "/kick target")
+	user.expect(t, "Only operators can use /kick")
+	user.send(t, "/topic")
+	user.expect(t, "#lobby has no topic") // anyone may read it
+}
+
+func TestKick(t *testing.T) {
+	op := oper(t, "kicker")
+	victim := connect(t, "victim")
+	op.send(t, "/kick victim spamming")
+	victim.expect(t, "You were kicked by kicker (spamming)")
+	op.expect(t, "victim was kicked by kicker (spamming)")
+	for range victim.lines {
+		// Drain until the server hangs up.
+	}
+	op.send(t, "/kick victim")
+	op.expect(t, "No such user online: victim")
+	connect(t, "victim") // kicked, not banned
+}
+
+// TestMuteOutlastsConnection checks that a muted user cannot get their
+// voice back by reconnecting or by changing nickname.
+func TestMuteOutlastsConnection(t *testing.T) {
+	op := oper(t, "muter")
+	loud := connect(t, "loud")
+	op.send(t, "/mute loud 1h too loud")
+	loud.expect(t, "You were muted for 1h0m0s by muter (too loud)")
+	loud.send(t, "hello")
+	loud.expect(t, "You are muted for another")
+
+	loud.conn.Close()
+	op.expect(t, "loud has left")
+	loud = connect(t, "loud")
+	loud.expect(t, "You are muted for another")
+	loud.send(t, "/nick quiet")
+	loud.send(t, "hello")
+	loud.expect(t, "You are muted for another")
+
+	op.send(t, "/unmute quiet")
+	loud.expect(t, "You were unmuted by muter")
+	loud.send(t, "hello again")
+	op.expect(t, "quiet: hello again")
+	op.send(t, "/unmute quiet")
+	op.expect(t, "quiet is not muted")
+}
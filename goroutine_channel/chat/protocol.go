@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The chat speaks two protocols on the same port. Text clients, such
+// as netcat, send and receive plain lines. A client that opens with a
+// hello frame instead,
+//
+//	{"type":"hello","versions":[1],"nick":"bot"}
+//
+// is answered with a hello frame naming the version chosen, and from
+// then on both sides exchange frames, one JSON object per line. The
+// client sends message frames, whose text may be a /command; the
+// server acknowledges each one that carries an id.
+const protocolVersion = 1
+
+// Frame types.
+const (
+	frameHello   = "hello"
+	frameMessage = "message" // chat, direct or replayed from history
+	frameJoin    = "join"    // someone entered the room
+	frameLeave   = "leave"   // someone left the room
+	frameSystem  = "system"  // a notice or a reply to a command
+	frameError   = "error"
+	frameAck     = "ack"
+)
+
+// server is the sender of frames that come from no client.
+const server = "server"
+
+// A frame is one event for a client. Text clients see only line, the
+// rendering the chat has always sent; JSON clients see the rest.
+type frame struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	From     string    `json:"from,omitempty"`
+	Room     string    `json:"room,omitempty"`
+	To       string    `json:"to,omitempty"` // recipient of a direct message
+	Text     string    `json:"text,omitempty"`
+	ID       string    `json:"id,omitempty"`       // the client's id, echoed by ack and error
+	History  bool      `json:"history,omitempty"`  // replayed from the history log
+	Version  int       `json:"version,omitempty"`  // hello: the version chosen
+	Versions []int     `json:"versions,omitempty"` // hello: the versions the client speaks
+	Nick     string    `json:"nick,omitempty"`     // hello: the nickname the client wants
+
+	line string // the text rendering; empty to skip the frame for text clients
+}
+
+// systemFrame returns a notice from the server.
+func systemFrame(text string) frame {
+	return frame{Type: frameSystem, Time: time.Now(), From: server, Text: text, line: text}
+}
+
+// errorFrame returns an error reply to the client frame with the given id.
+func errorFrame(id, text string) frame {
+	return frame{Type: frameError, Time: time.Now(), From: server, ID: id, Text: text, line: text}
+}
+
+// presenceFrame returns a join or leave notice about who in room.
+func presenceFrame(typ, who, room, text string) frame {
+	return frame{Type: typ, Time: time.Now(), From: who, Room: room, Text: text, line: text}
+}
+
+// frame returns e as a message replayed from history.
+func (e entry) frame() frame {
+	return frame{Type: frameMessage, Time: e.Time, From: e.From, Room: e.Room, Text: e.Text, History: true, line: e.String()}
+}
+
+// negotiate picks the newest version the server and the client both
+// speak, given a client hello. It reports false if there is none.
+func negotiate(hello frame) (int, bool) {
+	if len(hello.Versions) == 0 && hello.Version != 0 {
+		hello.Versions = []int{hello.Version}
+	}
+	best := 0
+	for _, v := range hello.Versions {
+		if v > best && v <= protocolVersion {
+			best = v
+		}
+	}
+	return best, best > 0
+}
+
+// parseHello reports whether line is a client hello frame.
+func parseHello(line string) (frame, bool) {
+	var f frame
+	if len(line) == 0 || line[0] != '{' || json.Unmarshal([]byte(line), &f) != nil {
+		return frame{}, false
+	}
+	return f, f.Type == frameHello
+}
+
+// writeFrame writes f to w as a JSON line, or as its text rendering if
+// the client has not negotiated JSON. Each frame is a single Write, so
+// the WebSocket gateway sends it as one message.
+func writeFrame(w io.Writer, f frame, asJSON bool) error {
+	if !asJSON {
+		if f.line == "" {
+			return nil
+		}
+		_, err := fmt.Fprintln(w, f.line)
+		return err
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// next reads the next line from cli: the line itself from a text
+// client, or the text of the next message frame from a JSON one, which
+// is acknowledged if it has an id. Other frames are answered with an
+// error and skipped. next reports false once the client has gone.
+func next(cli *client, in input, asJSON bool) (string, bool) {
+	for in.Scan() {
+		if !asJSON {
+			return in.Text(), true
+		}
+		var f frame
+		if err := json.Unmarshal(in.Bytes(), &f); err != nil {
+			cli.ch <- errorFrame("", "invalid frame: "+err.Error())
+			continue
+		}
+		switch {
+		case f.Type != frameMessage:
+			cli.ch <- errorFrame(f.ID, fmt.Sprintf("unexpected %q frame", f.Type))
+			continue
+		case strings.ContainsAny(f.Text, "\r\n"):
+			cli.ch <- errorFrame(f.ID, "text must be a single line")
+			continue
+		}
+		if f.ID != "" {
+			cli.ch <- frame{Type: frameAck, Time: time.Now(), From: server, ID: f.ID}
+		}
+		return f.Text, true
+	}
+	return "", false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Overflow policies for a client whose outbound queue is full,
+// chosen with the -overflow flag.
+const (
+	dropOldest = "drop-oldest" // discard the oldest queued message
+	disconnect = "disconnect"  // drop the client with a notice
+)
+
+// send queues f for cli without ever blocking the broadcaster, so one
+// client that stops reading cannot stall the room. When cli's queue is
+// full the -overflow policy decides what gives. send must only be
+// called by the broadcaster.
+func send(cli *client, f frame) {
+	if cli.kicked {
+		return
+	}
+	for {
+		select {
+		case cli.ch <- f:
+			return
+		default:
+		}
+		cli.dropped++
+		if cli.dropped == 1 || cli.dropped%100 == 0 {
+			log.Printf("%s: outbound queue full, %d messages dropped so far", cli.name, cli.dropped)
+		}
+		// Make room by discarding the oldest frame. clientWriter may
+		// take one at the same time, in which case there is room anyway.
+		select {
+		case <-cli.ch:
+		default:
+		}
+		if *overflow == disconnect {
+			kick(cli, systemFrame("Disconnected: you are not reading messages fast enough"))
+			return
+		}
+	}
+}
+
+// kick queues a final notice for cli and cuts its connection. The
+// expired read deadline makes handleConn's pending Scan fail, so the
+// client leaves through the usual leaving path, where the broadcaster
+// closes its channel and removes it if nothing has yet. The write
+// deadline gives the peer a moment to take the notice and then unblocks
+// clientWriter if it never does.
+func kick(cli *client, notice frame) {
+	select {
+	case cli.ch <- notice:
+	default:
+	}
+	cli.kicked = true
+	cli.conn.SetReadDeadline(time.Now())
+	cli.conn.SetWriteDeadline(time.Now().Add(time.Second))
+}
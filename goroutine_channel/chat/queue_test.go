@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// stalled connects a client under nick that never reads, so its queue
+// fills up, and returns a channel closed once handleConn is done.
+func stalled(t *testing.T, nick string) <-chan struct{} {
+	t.Helper()
+	server, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	done := make(chan struct{})
+	go func() {
+		handleConn(server)
+		close(done)
+	}()
+	c := &testClient{conn: conn}
+	c.send(t, nick)
+	return done
+}
+
+// waitDone waits for a stalled client's handleConn to return.
+func waitDone(t *testing.T, done <-chan struct{}, nick string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s was not disconnected", nick)
+	}
+}
+
+// TestStalledReaderInRoom fills a stalled client's queue with room
+// messages, which must disconnect it without holding up the room.
+func TestStalledReaderInRoom(t *testing.T) {
+	bob := connect(t, "bob")
+	done := stalled(t, "slowbob")
+	bob.expect(t, "slowbob has arrived")
+	for i := 0; i < 2**queueSize; i++ {
+		bob.send(t, "line "+strconv.Itoa(i))
+	}
+	bob.expect(t, "slowbob was disconnected for not keeping up")
+	bob.expect(t, "bob: line "+strconv.Itoa(2**queueSize-1))
+	waitDone(t, done, "slowbob")
+
+	connect(t, "slowbob") // the name is free again
+}
+
+// TestStalledReaderDirect fills a stalled client's queue with direct
+// messages, outside any broadcast to its room. It must still be
+// removed once it is cut off, not linger holding its name.
+func TestStalledReaderDirect(t *testing.T) {
+	alice := connect(t, "alice")
+	done := stalled(t, "slowalice")
+	alice.expect(t, "slowalice has arrived")
+	for i := 0; i < 2**queueSize; i++ {
+		alice.send(t, "/msg slowalice hello")
+	}
+	waitDone(t, done, "slowalice")
+	alice.expect(t, "slowalice was disconnected for not keeping up")
+	connect(t, "slowalice")
+}
+
+// TestDropOldestKeepsJSON fills the queue of a JSON client that is not
+// reading under drop-oldest, so that its hello frame is discarded, and
+// checks that it still gets JSON frames.
+func TestDropOldestKeepsJSON(t *testing.T) {
+	saved := *overflow
+	*overflow = dropOldest
+	defer func() { *overflow = saved }()
+
+	talker := connect(t, "talker")
+	server, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	go handleConn(server)
+	bot := &testClient{conn: conn}
+	bot.send(t, `{"type":"hello","versions":[1],"nick":"bot"}`)
+	talker.expect(t, "bot has arrived")
+	n := 2 * *queueSize
+	for i := 0; i < n; i++ {
+		talker.send(t, "msg "+strconv.Itoa(i))
+	}
+	talker.send(t, "/who") // answered once every msg is queued
+	talker.expect(t, "in #lobby")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	in := bufio.NewScanner(conn)
+	in.Scan() // the nickname prompt, written before the hello was read
+	last := "msg " + strconv.Itoa(n-1)
+	for in.Scan() {
+		var f frame
+		if err := json.Unmarshal(in.Bytes(), &f); err != nil {
+			t.Fatalf("got %q, want a JSON frame", in.Text())
+		}
+		if f.Text == last {
+			return
+		}
+	}
+	t.Fatalf("no frame %q: %v", last, in.Err())
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The WebSocket gateway lets browsers join the same chat as TCP
+// clients. Each upgraded connection is wrapped in a wsConn, which looks
+// like a line-oriented net.Conn, and handed to handleConn, so browser
+// users go through the same login, commands and broadcaster as
+// everyone else. Only the parts of RFC 6455 a chat needs are here:
+// text messages, fragmentation, ping/pong and close.
+
+//go:embed client.html
+var clientHTML []byte
+
+const (
+	wsGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxWSMessage = 64 << 10
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+var errBadFrame = errors.New("websocket: protocol error")
+
+// serveHTTP serves the browser client at / and the gateway at /ws.
+func serveHTTP(addr string) {
+	log.Fatal(http.ListenAndServe(addr, newMux()))
+}
+
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(clientHTML)
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, req *http.Request) {
+		if notice, banned := refuse(req.RemoteAddr); banned {
+			http.Error(w, notice, http.StatusForbidden)
+			return
+		}
+		if !sameOrigin(req) {
+			log.Printf("websocket: %s: refused, cross-origin from %s", req.RemoteAddr, req.Header.Get("Origin"))
+			http.Error(w, "cross-origin WebSocket connections are not allowed", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrade(w, req)
+		if err != nil {
+			log.Printf("websocket: %v", err)
+			return
+		}
+		handleConn(conn)
+	})
+	return mux
+}
+
+// sameOrigin reports whether req comes from a page served by this
+// server. Browsers send Origin with every WebSocket handshake and,
+// unlike other requests, do not stop another site's page from opening
+// one, so without this check any page a user visits could chat as
+// them. Clients other than browsers send no Origin and are let in.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// upgrade performs the opening handshake and takes over the connection.
+func upgrade(w http.ResponseWriter, req *http.Request) (*wsConn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("bad handshake from " + req.RemoteAddr)
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot upgrade", http.StatusInternalServerError)
+		return nil, errors.New("response does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{Conn: conn, r: rw.Reader}, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A wsConn turns WebSocket messages into newline-terminated lines for
+// Read, and sends each Write as one text message. Deadlines, addresses
+// and Close come from the underlying connection, so the idle watch and
+// the slow-client kick work unchanged.
+type wsConn struct {
+	net.Conn
+	r       *bufio.Reader
+	pending []byte // rest of the current message, for Read
+
+	wmu       sync.Mutex // clientWriter and the control replies of Read both write
+	closeSent bool       // no frames may follow a close frame
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		// One message is one chat line, whatever it contains.
+		msg = []byte(strings.NewReplacer("\r", " ", "\n", " ").Replace(string(msg)))
+		c.pending = append(msg, '\n')
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readMessage reads frames until it has a whole data message, answering
+// pings and close frames on the way.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+		case opText, opBinary:
+			if started {
+				return nil, errBadFrame
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, errBadFrame
+			}
+		default:
+			return nil, errBadFrame
+		}
+		if len(msg)+len(payload) > maxWSMessage {
+			c.writeFrame(opClose, []byte{0x03, 0xF1}) // 1009: message too big
+			return nil, errors.New("websocket: message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[0]&0x70 != 0 || hdr[1]&0x80 == 0 {
+		// Reserved bits set, or an unmasked frame from a client.
+		return false, 0, nil, errBadFrame
+	}
+	size := uint64(hdr[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > maxWSMessage || op >= opClose && (size > 125 || !fin) {
+		return false, 0, nil, errBadFrame
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 126, byte(n>>8), byte(n))
+	default:
+		hdr = append(hdr, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	c.closeSent = op == opClose
+	if _, err := c.Conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := c.Conn.Write(payload)
+	return err
+}
+
+// Write sends p, less its trailing newline, as one text message. Text
+// messages must be UTF-8, which raw TCP clients do not promise.
+func (c *wsConn) Write(p []byte) (int, error) {
+	s := strings.TrimSuffix(string(p), "\n")
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "�")
+	}
+	if err := c.writeFrame(opText, []byte(s)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame, best effort, and closes the connection.
+func (c *wsConn) Close() error {
+	c.writeFrame(opClose, []byte{0x03, 0xE8}) // 1000: normal closure
+	return c.Conn.Close()
+}
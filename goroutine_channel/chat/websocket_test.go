@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A wsClient is the browser end of a WebSocket connection.
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// handshake sends an opening handshake with the given extra headers to
+// srv and returns the response. The connection is left open if the
+// server switched protocols.
+func handshake(t *testing.T, srv *httptest.Server, header http.Header) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, err := http.NewRequest("GET", srv.URL+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{conn, r}, resp
+}
+
+// dialWS connects a browser client that has passed the handshake.
+func dialWS(t *testing.T, srv *httptest.Server) *wsClient {
+	t.Helper()
+	c, resp := handshake(t, srv, nil)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %s", resp.Status)
+	}
+	return c
+}
+
+// writeFrame sends a frame masked, as clients must.
+func (c *wsClient) writeFrame(t *testing.T, fin bool, op byte, payload []byte) {
+	t.Helper()
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	hdr := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, 0x80|byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 0x80|126, byte(n>>8), byte(n))
+	default:
+		hdr = append(hdr, 0x80|127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	if _, err := c.conn.Write(append(append(hdr, mask...), masked...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFrame reads an unmasked frame from the server.
+func (c *wsClient) readFrame(t *testing.T) (op byte, payload []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	if hdr[0]&0x80 == 0 || hdr[1]&0x80 != 0 {
+		t.Fatalf("server sent a fragmented or masked frame: % x", hdr)
+	}
+	size := uint64(hdr[1])
+	switch size {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	return hdr[0] & 0x0F, payload
+}
+
+// expect reads text messages until one contains want.
+func (c *wsClient) expect(t *testing.T, want string) {
+	t.Helper()
+	for {
+		op, payload := c.readFrame(t)
+		if op == opText && strings.Contains(string(payload), want) {
+			return
+		}
+		if op == opClose {
+			t.Fatalf("closed waiting for %q", want)
+		}
+	}
+}
+
+// expectClose reads until a close frame and returns its status code.
+func (c *wsClient) expectClose(t *testing.T) uint16 {
+	t.Helper()
+	for {
+		op, payload := c.readFrame(t)
+		if op == opClose {
+			if len(payload) < 2 {
+				return 0
+			}
+			return binary.BigEndian.Uint16(payload)
+		}
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"plain", nil, http.StatusSwitchingProtocols},
+		{"same origin", http.Header{"Origin": {srv.URL}}, http.StatusSwitchingProtocols},
+		{"cross origin", http.Header{"Origin": {"http://evil.example"}}, http.StatusForbidden},
+		{"bad origin", http.Header{"Origin": {"::"}}, http.StatusForbidden},
+		{"old version", http.Header{"Sec-Websocket-Version": {"8"}}, http.StatusBadRequest},
+		{"no upgrade", http.Header{"Upgrade": {"h2c"}}, http.StatusBadRequest},
+		{"no key", http.Header{"Sec-Websocket-Key": {""}}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		_, resp := handshake(t, srv, test.header)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, resp.StatusCode, test.status)
+			continue
+		}
+		if test.status != http.StatusSwitchingProtocols {
+			continue
+		}
+		// The accept key for this request key is given in RFC 6455.
+		if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+			t.Errorf("%s: Sec-WebSocket-Accept %q, want %q", test.name, got, want)
+		}
+	}
+}
+
+// TestWebSocketChat checks that browser and TCP clients share the chat.
+func TestWebSocketChat(t *testing.T) {
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	c := dialWS(t, srv)
+	c.expect(t, "Enter your nickname:")
+	c.writeFrame(t, true, opText, []byte("browser"))
+	c.expect(t, "You are browser")
+
+	carol := connect(t, "carol")
+	carol.send(t, "hello from tcp")
+	c.expect(t, "carol: hello from tcp")
+
+	// Messages longer than 125 bytes need an extended length.
+	long := strings.Repeat("x", 300)
+	c.writeFrame(t, true, opText, []byte(long))
+	carol.expect(t, "browser: "+long)
+
+	// A message is one line, even with line breaks in it.
+	c.writeFrame(t, true, opText, []byte("two\nlines"))
+	carol.expect(t, "browser: two lines")
+}
+
+func TestWebSocketFragmentation(t *testing.T) {
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	c := dialWS(t, srv)
+	c.expect(t, "Enter your nickname:")
+	c.writeFrame(t, false, opText, []byte("frag"))
+	c.writeFrame(t, true, opPing, []byte("are you there"))
+	if op, payload := c.readFrame(t); op != opPong || string(payload) != "are you there" {
+		t.Fatalf("ping answered with op %#x %q, want a pong echoing it", op, payload)
+	}
+	c.writeFrame(t, false, opContinuation, []byte("ment"))
+	c.writeFrame(t, true, opContinuation, []byte("ed"))
+	c.expect(t, "You are fragmented")
+}
+
+// TestWebSocketBadFrames checks that protocol errors cut the client off.
+func TestWebSocketBadFrames(t *testing.T) {
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	tests := []struct {
+		name string
+		send func(t *testing.T, c *wsClient)
+	}{
+		{"continuation first", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, true, opContinuation, []byte("x"))
+		}},
+		{"text inside a message", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, false, opText, []byte("x"))
+			c.writeFrame(t, true, opText, []byte("y"))
+		}},
+		{"fragmented ping", func(t *testing.T, c *wsClient) {
+			c.writeFrame(t, false, opPing, nil)
+		}},
+		{"unmasked", func(t *testing.T, c *wsClient) {
+			c.conn.Write([]byte{0x81, 0x01, 'x'})
+		}},
+	}
+	for _, test := range tests {
+		c := dialWS(t, srv)
+		c.expect(t, "Enter your nickname:")
+		test.send(t, c)
+		if code := c.expectClose(t); code != 1000 {
+			t.Errorf("%s: close code %d, want 1000", test.name, code)
+		}
+		if _, err := c.r.ReadByte(); err != io.EOF {
+			t.Errorf("%s: connection still open after close: %v", test.name, err)
+		}
+	}
+
+	c := dialWS(t, srv)
+	c.expect(t, "Enter your nickname:")
+	c.writeFrame(t, false, opText, make([]byte, maxWSMessage))
+	c.writeFrame(t, true, opContinuation, []byte("x"))
+	if code := c.expectClose(t); code != 1009 {
+		t.Errorf("oversized message: close code %d, want 1009", code)
+	}
+}
+
+// TestWebSocketClose checks the closing handshake, and that the client
+// leaves the chat like any other.
+func TestWebSocketClose(t *testing.T) {
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+	dave := connect(t, "dave")
+	c := dialWS(t, srv)
+	c.expect(t, "Enter your nickname:")
+	c.writeFrame(t, true, opText, []byte("closer"))
+	dave.expect(t, "closer has arrived")
+	c.writeFrame(t, true, opClose, []byte{0x03, 0xE8})
+	c.expectClose(t)
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open after close: %v", err)
+	}
+	dave.expect(t, "closer has left")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const auditFile = "audit.log"
+
+// An auditEvent records one change to one item and who made it.
+// Prices and stock levels are nil when the change did not touch them,
+// or when the item did not exist before (old) or after (new) it.
+type auditEvent struct {
+	Time      time.Time `json:"time"`
+	Item      string    `json:"item"`
+	Action    string    `json:"action"` // "create", "update", "delete", "stock" or "order"
+	OldPrice  *money    `json:"old_price,omitempty"`
+	NewPrice  *money    `json:"new_price,omitempty"`
+	OldStock  *int64    `json:"old_stock,omitempty"`
+	NewStock  *int64    `json:"new_stock,omitempty"`
+	User      string    `json:"user"`
+	Remote    string    `json:"remote,omitempty"` // the address the request came from
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// An actor identifies who made a change.
+type actor struct {
+	user      string
+	remote    string
+	requestID string
+}
+
+// system is the actor for changes the server makes on its own.
+var system = actor{user: "system"}
+
+// trusted holds the -trusted-proxy networks.
+var trusted []*net.IPNet
+
+// parseProxies parses a comma-separated list of IP addresses and CIDR
+// networks, such as "127.0.0.1,10.0.0.0/8".
+func parseProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", p)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q: %v", p, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// actorOf identifies the caller behind req. Nothing here checks a
+// password, so the basic-auth user name or X-User header is believed
+// only from a -trusted-proxy that has authenticated the user; anyone
+// else is known by IP address. The address is recorded either way.
+func actorOf(req *http.Request) actor {
+	remote := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	user := remote
+	if fromTrustedProxy(remote) {
+		name, _, ok := req.BasicAuth()
+		if !ok || name == "" {
+			name = req.Header.Get("X-User")
+		}
+		if name != "" {
+			user = name
+		}
+	}
+	return actor{user, remote, requestID(req)}
+}
+
+func fromTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	for _, n := range trusted {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// An auditLog is an append-only JSON-lines file of every change ever
+// made, kept apart from the write-ahead log because snapshots empty the
+// latter. Events are also indexed by item in memory.
+//
+// An event is appended after its change reached the write-ahead log, so
+// a crash in between can lose an event but never invent one.
+type auditLog struct {
+	f      *os.File
+	byItem map[string][]auditEvent // in time order
+}
+
+func openAudit(dir string) (*auditLog, error) {
+	f, err := os.OpenFile(filepath.Join(dir, auditFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{f: f, byItem: make(map[string][]auditEvent)}
+	in := bufio.NewScanner(f)
+	in.Buffer(nil, maxRecord)
+	var good int64
+	for in.Scan() {
+		var ev auditEvent
+		if err := json.Unmarshal(in.Bytes(), &ev); err != nil {
+			break
+		}
+		a.byItem[ev.Item] = append(a.byItem[ev.Item], ev)
+		good += int64(len(in.Bytes())) + 1
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() > good {
+		log.Printf("audit: torn event at offset %d, truncating", good)
+		if err := f.Truncate(good); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *auditLog) append(ev auditEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	a.byItem[ev.Item] = append(a.byItem[ev.Item], ev)
+	return nil
+}
+
+// priceAt returns the price item had at time t, if it existed then.
+func (a *auditLog) priceAt(item string, t time.Time) (money, bool) {
+	events := a.byItem[item]
+	i := sort.Search(len(events), func(i int) bool { return events[i].Time.After(t) })
+	for i--; i >= 0; i-- {
+		switch ev := events[i]; ev.Action {
+		case "create", "update":
+			return *ev.NewPrice, true
+		case "delete":
+			return money{}, false
+		}
+	}
+	return money{}, false
+}
+
+// touches returns the items rec changes.
+func (rec record) touches() []string {
+	if rec.Op != "order" {
+		return []string{rec.Item}
+	}
+	var items []string
+	seen := make(map[string]bool)
+	for _, l := range rec.Lines {
+		if !seen[l.Item] {
+			seen[l.Item] = true
+			items = append(items, l.Item)
+		}
+	}
+	return items
+}
+
+// priceOf and stockOf return an item's current price and stock level,
+// or nil if it has none.
+func (st *state) priceOf(item string) *money {
+	if p, ok := st.items[item]; ok {
+		return &p
+	}
+	return nil
+}
+
+func (st *state) stockOf(item string) *int64 {
+	if s, ok := st.stock[item]; ok {
+		return &s
+	}
+	return nil
+}
+
+// audit records that by changed item with rec, given the item's price
+// and stock level from just before rec was applied.
+func (db *database) audit(rec record, by actor, item string, oldPrice *money, oldStock *int64) {
+	ev := auditEvent{
+		Time:      time.Now().UTC(),
+		Item:      item,
+		Action:    rec.Op,
+		User:      by.user,
+		Remote:    by.remote,
+		RequestID: by.requestID,
+	}
+	switch rec.Op {
+	case "set":
+		ev.Action = "update"
+		if oldPrice == nil {
+			ev.Action = "create"
+		}
+		ev.OldPrice, ev.NewPrice = oldPrice, db.priceOf(item)
+	case "delete":
+		ev.OldPrice, ev.OldStock = oldPrice, oldStock
+	case "stock", "order":
+		ev.OldStock, ev.NewStock = oldStock, db.stockOf(item)
+	}
+	if err := db.auditLog.append(ev); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// history writes the change timeline of an item: GET /history?item=socks.
+func (db *database) history(w http.ResponseWriter, req *http.Request) {
+	item := req.URL.Query().Get("item")
+	db.mu.Lock()
+	events := append([]auditEvent(nil), db.auditLog.byItem[item]...)
+	db.mu.Unlock()
+	if len(events) == 0 {
+		httpError(w, req, http.StatusNotFound, "no history for item: %q", item)
+		return
+	}
+	if wantsJSON(req) {
+		writeJSON(w, http.StatusOK, struct {
+			Item   string       `json:"item"`
+			Events []auditEvent `json:"events"`
+		}{item, events})
+		return
+	}
+	for _, ev := range events {
+		fmt.Fprintf(w, "%s %s %s by %s", ev.Time.Format(time.RFC3339), ev.Action, ev.Item, ev.User)
+		if ev.Remote != "" && ev.Remote != ev.User {
+			fmt.Fprintf(w, " from %s", ev.Remote)
+		}
+		if ev.OldPrice != nil || ev.NewPrice != nil {
+			fmt.Fprintf(w, " price %s -> %s", optMoney(ev.OldPrice), optMoney(ev.NewPrice))
+		}
+		if ev.OldStock != nil || ev.NewStock != nil {
+			fmt.Fprintf(w, " stock %s -> %s", optInt(ev.OldStock), optInt(ev.NewStock))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func optMoney(m *money) string {
+	if m == nil {
+		return "-"
+	}
+	return m.String()
+}
+
+func optInt(n *int64) string {
+	if n == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
+// parseTime parses the at parameter of /price: an RFC 3339 timestamp,
+// or a date, which stands for the end of that day in UTC.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return time.Time{}, errors.New("invalid time: want RFC 3339 (2006-01-02T15:04:05Z) or a date (2006-01-02)")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActorOf(t *testing.T) {
+	var err error
+	if trusted, err = parseProxies("10.0.0.1, 192.168.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { trusted = nil }()
+	tests := []struct {
+		remote, xUser, basic string
+		want                 actor
+	}{
+		{"203.0.113.7:5000", "", "", actor{"203.0.113.7", "203.0.113.7", ""}},
+		{"203.0.113.7:5000", "admin", "", actor{"203.0.113.7", "203.0.113.7", ""}},
+		{"203.0.113.7:5000", "", "admin", actor{"203.0.113.7", "203.0.113.7", ""}},
+		{"10.0.0.1:5000", "alice", "", actor{"alice", "10.0.0.1", ""}},
+		{"192.168.4.2:5000", "", "bob", actor{"bob", "192.168.4.2", ""}},
+		{"10.0.0.1:5000", "", "", actor{"10.0.0.1", "10.0.0.1", ""}},
+		{"10.0.0.2:5000", "alice", "", actor{"10.0.0.2", "10.0.0.2", ""}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/update", nil)
+		req.RemoteAddr = test.remote
+		if test.xUser != "" {
+			req.Header.Set("X-User", test.xUser)
+		}
+		if test.basic != "" {
+			req.SetBasicAuth(test.basic, "not checked")
+		}
+		if got := actorOf(req); got != test.want {
+			t.Errorf("actorOf(remote %s, X-User %q, basic %q) = %+v, want %+v",
+				test.remote, test.xUser, test.basic, got, test.want)
+		}
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	for _, bad := range []string{"localhost", "10.0.0.0/33", "1.2.3"} {
+		if _, err := parseProxies(bad); err == nil {
+			t.Errorf("parseProxies(%q) succeeded, want error", bad)
+		}
+	}
+	nets, err := parseProxies(" ::1 ,")
+	if err != nil || len(nets) != 1 || nets[0].String() != "::1/128" {
+		t.Errorf("parseProxies(\" ::1 ,\") = %v, %v, want [::1/128]", nets, err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// itemETag tags one representation of an item's price at version, and
+// stockETag one of its stock level. Like listETag they differ per
+// format because the bodies differ.
+func itemETag(version uint64, format string) string {
+	return fmt.Sprintf(`"%d-%s"`, version, format)
+}
+
+func stockETag(version uint64, format string) string {
+	return fmt.Sprintf(`"stock-%d-%s"`, version, format)
+}
+
+// formats lists every response format, for matching an If-Match tag
+// taken from any of them.
+var formats = []string{formatText, formatJSON, formatHTML}
+
+// listETag tags one representation of /list. It changes whenever any
+// item changes, and differs per format because the bodies differ.
+func listETag(seq uint64, format string) string { return fmt.Sprintf(`"list-%d-%s"`, seq, format) }
+
+// etagMatches reports whether etag appears in an If-Match or
+// If-None-Match header value. With weak set, W/ prefixes are ignored as
+// RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces an If-Match precondition on a change to an item
+// now at version, whose tags tag makes, replying 412 if the client's
+// copy is stale. A tag read in any format matches, since a client may
+// read JSON and then post a form. Requests without If-Match are let
+// through.
+func checkIfMatch(w http.ResponseWriter, req *http.Request, version uint64, tag func(uint64, string) string) bool {
+	h := req.Header.Get("If-Match")
+	if h == "" {
+		return true
+	}
+	for _, f := range formats {
+		if etagMatches(h, tag(version, f), false) {
+			return true
+		}
+	}
+	etag := tag(version, negotiate(req))
+	w.Header().Set("ETag", etag)
+	httpError(w, req, http.StatusPreconditionFailed, "item has changed: current version is %s", etag)
+	return false
+}
+
+// notModified sets the ETag header of a GET response and, if the client
+// already holds that version, replies 304 and reports true.
+func notModified(w http.ResponseWriter, req *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if h := req.Header.Get("If-None-Match"); h != "" && etagMatches(h, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
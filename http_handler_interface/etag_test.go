@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func get(h http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPriceETagPerFormat(t *testing.T) {
+	db := newTestDB(t, map[string][2]int64{"socks": {500, 10}})
+	h := http.HandlerFunc(db.price)
+	text := get(h, "/price?item=socks", nil).Header().Get("ETag")
+	js := get(h, "/price?item=socks", map[string]string{"Accept": "application/json"}).Header().Get("ETag")
+	if text == "" || text == js {
+		t.Fatalf("text ETag %q, JSON ETag %q: want two different tags", text, js)
+	}
+	if rec := get(h, "/price?item=socks", map[string]string{"If-None-Match": text}); rec.Code != http.StatusNotModified {
+		t.Errorf("text with its own tag: status %d, want 304", rec.Code)
+	}
+	rec := get(h, "/price?item=socks", map[string]string{"Accept": "application/json", "If-None-Match": text})
+	if rec.Code != http.StatusOK {
+		t.Errorf("JSON with the text tag: status %d, want 200", rec.Code)
+	}
+}
+
+func TestOrderKeepsPriceETag(t *testing.T) {
+	db := newTestDB(t, map[string][2]int64{"socks": {500, 10}})
+	tag := get(http.HandlerFunc(db.price), "/price?item=socks", map[string]string{"Accept": "application/json"}).Header().Get("ETag")
+	stockTag := get(http.HandlerFunc(db.stockLevel), "/stock?item=socks", nil).Header().Get("ETag")
+
+	if rec := post(http.HandlerFunc(db.order), "/order", url.Values{"item": {"socks"}, "qty": {"2"}}); rec.Code != http.StatusOK {
+		t.Fatalf("order: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := get(http.HandlerFunc(db.price), "/price?item=socks", map[string]string{"Accept": "application/json"}).Header().Get("ETag"); got != tag {
+		t.Errorf("price ETag changed from %s to %s by a sale", tag, got)
+	}
+	if got := get(http.HandlerFunc(db.stockLevel), "/stock?item=socks", nil).Header().Get("ETag"); got == stockTag {
+		t.Errorf("stock ETag %s unchanged by a sale", got)
+	}
+
+	update := func(ifMatch string) int {
+		req := httptest.NewRequest(http.MethodPost, "/update?item=socks&price=6", nil)
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		db.update(rec, req)
+		return rec.Code
+	}
+	if code := update(tag); code != http.StatusOK {
+		t.Errorf("update with the JSON tag read before the sale: status %d, want 200", code)
+	}
+	if code := update(tag); code != http.StatusPreconditionFailed {
+		t.Errorf("update with a stale tag: status %d, want 412", code)
+	}
+	if code := update(`"stale", *`); code != http.StatusOK {
+		t.Errorf("update with If-Match *: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// pages holds the HTML views served to browsers. html/template escapes
+// item names and messages for the context they appear in, so an item
+// called "<script>" is shown as text and never run.
+var pages = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Prices</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+form { display: inline; }
+</style>
+</head>
+<body>
+<h1>Prices</h1>
+<table>
+<tr><th>Item</th><th>Price</th><th></th></tr>
+{{range .}}
+<tr>
+  <td>{{.Item}}</td>
+  <td>{{.Display}}</td>
+  <td>
+    <form method="post" action="/update">
+      <input type="hidden" name="item" value="{{.Item}}">
+      <input type="text" name="price" value="{{.Amount}}" size="8">
+      <input type="hidden" name="currency" value="{{.Currency}}">
+      <button>Save</button>
+    </form>
+    <form method="post" action="/delete">
+      <input type="hidden" name="item" value="{{.Item}}">
+      <button>Delete</button>
+    </form>
+  </td>
+</tr>
+{{else}}
+<tr><td colspan="3">No items.</td></tr>
+{{end}}
+</table>
+<h2>Add an item</h2>
+<form method="post" action="/create">
+  <input type="text" name="item" placeholder="item" required>
+  <input type="text" name="price" placeholder="price, e.g. 12.50" required>
+  <input type="text" name="currency" value="USD" size="4">
+  <button>Add</button>
+</form>
+</body>
+</html>
+`))
+
+func init() {
+	template.Must(pages.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.Code}}</title></head>
+<body>
+<h1>{{.Code}}</h1>
+<p>{{.Message}}</p>
+<p><a href="/list">Back to prices</a></p>
+</body>
+</html>
+`))
+}
+
+// A row is one item as shown in the list page's table.
+type row struct {
+	Item     string
+	Display  string // e.g. "$1,234.50"
+	Amount   string // e.g. "1234.50", editable without the symbol
+	Currency string
+}
+
+func rows(entries []entry) []row {
+	var rs []row
+	for _, e := range entries {
+		rs = append(rs, row{e.Item, e.Display, e.Price.decimal(), e.Price.currency})
+	}
+	return rs
+}
+
+// writeHTML renders the named page with data.
+func writeHTML(w http.ResponseWriter, status int, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("rendering %s: %v", name, err)
+	}
+}
@@ -1,39 +1,472 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every flag defaults to the environment variable named after it.
+var (
+	addr         = flag.String("addr", envString("PRICEDB_ADDR", "localhost:8000"), "listen address ($PRICEDB_ADDR)")
+	dataDir      = flag.String("data", envString("PRICEDB_DATA", "pricedb"), "directory holding the snapshot and write-ahead log ($PRICEDB_DATA)")
+	interval     = flag.Duration("snapshot", envDuration("PRICEDB_SNAPSHOT", 5*time.Minute), "how often to snapshot the database; 0 only snapshots on shutdown ($PRICEDB_SNAPSHOT)")
+	readTimeout  = flag.Duration("read-timeout", envDuration("PRICEDB_READ_TIMEOUT", 10*time.Second), "maximum time to read a request ($PRICEDB_READ_TIMEOUT)")
+	writeTimeout = flag.Duration("write-timeout", envDuration("PRICEDB_WRITE_TIMEOUT", 10*time.Second), "maximum time to write a response ($PRICEDB_WRITE_TIMEOUT)")
+	idleTimeout  = flag.Duration("idle-timeout", envDuration("PRICEDB_IDLE_TIMEOUT", 2*time.Minute), "how long to keep idle connections open ($PRICEDB_IDLE_TIMEOUT)")
+	grace        = flag.Duration("shutdown-timeout", envDuration("PRICEDB_SHUTDOWN_TIMEOUT", 15*time.Second), "how long to drain requests on shutdown ($PRICEDB_SHUTDOWN_TIMEOUT)")
+	certFile     = flag.String("tls-cert", envString("PRICEDB_TLS_CERT", ""), "TLS certificate file; serves HTTPS with -tls-key ($PRICEDB_TLS_CERT)")
+	keyFile      = flag.String("tls-key", envString("PRICEDB_TLS_KEY", ""), "TLS private key file ($PRICEDB_TLS_KEY)")
+	proxies      = flag.String("trusted-proxy", envString("PRICEDB_TRUSTED_PROXY", ""), "comma-separated addresses or networks of proxies whose X-User header names the caller ($PRICEDB_TRUSTED_PROXY)")
 )
 
 func main() {
-	db := database{"shoes": 50, "socks": 5}
+	flag.Parse()
+	var err error
+	if trusted, err = parseProxies(*proxies); err != nil {
+		log.Fatal(err)
+	}
+	s, st, fresh, err := openStore(*dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	al, err := openAudit(*dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db := &database{state: st, store: s, auditLog: al}
+	if fresh {
+		for _, seed := range []struct {
+			item  string
+			price money
+			stock int64
+		}{
+			{"shoes", money{5000, "USD"}, 10},
+			{"socks", money{500, "USD"}, 100},
+		} {
+			if err := db.set(seed.item, seed.price, seed.stock, system); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	if *interval < 0 {
+		log.Fatal("-snapshot must not be negative")
+	}
+	stopSnapshots := func() {}
+	if *interval > 0 {
+		ticker := time.NewTicker(*interval)
+		go func() {
+			for range ticker.C {
+				if err := db.snapshot(); err != nil {
+					log.Printf("snapshot: %v", err)
+				}
+			}
+		}()
+		stopSnapshots = ticker.Stop
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/list", http.HandlerFunc(db.list))
 	mux.Handle("/price", http.HandlerFunc(db.price))
-	log.Fatal(http.ListenAndServe("localhost:8000", mux))
+	mux.Handle("/create", http.HandlerFunc(db.create))
+	mux.Handle("/update", http.HandlerFunc(db.update))
+	mux.Handle("/delete", http.HandlerFunc(db.delete))
+	mux.Handle("/stock", http.HandlerFunc(db.stockLevel))
+	mux.Handle("/order", http.HandlerFunc(db.order))
+	mux.Handle("/history", http.HandlerFunc(db.history))
+	srv := &http.Server{
+		Addr:         *addr,
+		Handler:      chain(mux, withRequestID, accessLog, recoverPanic, sameOrigin),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	if err := serve(srv, *certFile, *keyFile, *grace); err != nil {
+		log.Fatal(err)
+	}
+
+	// All requests are done; leave a fresh snapshot so the next start
+	// has no log to replay.
+	stopSnapshots()
+	if err := db.snapshot(); err != nil {
+		log.Fatalf("snapshot: %v", err)
+	}
+	db.close()
+	log.Print("stopped")
 }
 
-type dollars float32
+// database is safe for concurrent use: net/http calls each handler
+// in its own goroutine, so every access to items and stock holds mu.
+// Changes are logged to store before they are applied to the state.
+type database struct {
+	mu sync.Mutex
+	state
+	store    *store
+	auditLog *auditLog
+}
+
+// applyLocked durably applies rec on behalf of by and records the
+// change in the audit log. Callers must hold db.mu.
+func (db *database) applyLocked(rec record, by actor) error {
+	items := rec.touches()
+	oldPrices := make([]*money, len(items))
+	oldStock := make([]*int64, len(items))
+	for i, item := range items {
+		oldPrices[i], oldStock[i] = db.priceOf(item), db.stockOf(item)
+	}
+	rec.Seq = db.seq + 1
+	if err := db.store.append(rec); err != nil {
+		return err
+	}
+	rec.apply(&db.state)
+	for i, item := range items {
+		db.audit(rec, by, item, oldPrices[i], oldStock[i])
+	}
+	return nil
+}
+
+// setLocked durably sets the price of item. Callers must hold db.mu.
+func (db *database) setLocked(item string, price money, by actor) error {
+	return db.applyLocked(record{Op: "set", Item: item, Price: &price}, by)
+}
 
-func (d dollars) String() string { return fmt.Sprintf("$%.2f", d) }
+// deleteLocked durably removes item. Callers must hold db.mu.
+func (db *database) deleteLocked(item string, by actor) error {
+	return db.applyLocked(record{Op: "delete", Item: item}, by)
+}
 
-type database map[string]dollars
+// set durably sets the price and stock level of item.
+func (db *database) set(item string, price money, stock int64, by actor) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.setLocked(item, price, by); err != nil {
+		return err
+	}
+	return db.applyLocked(record{Op: "stock", Item: item, Qty: stock}, by)
+}
 
-func (db database) list(w http.ResponseWriter, req *http.Request) {
-	fmt.Println(*req)
-	for item, price := range db {
-		fmt.Fprintf(w, "%s: %s\n", item, price)
+func (db *database) close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.store.close()
+	db.auditLog.f.Close()
+}
+
+func (db *database) snapshot() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.store.snapshot(db.state)
+}
+
+// storageError reports a failure to persist a change.
+func storageError(w http.ResponseWriter, req *http.Request, err error) {
+	log.Printf("storage: %v", err)
+	httpError(w, req, http.StatusInternalServerError, "storage error")
+}
+
+// An entry is the JSON form of one item and its price.
+type entry struct {
+	Item    string `json:"item"`
+	Price   money  `json:"price"`
+	Display string `json:"display"` // price formatted for people, e.g. "$5.00"
+}
+
+func newEntry(item string, price money) entry { return entry{item, price, price.String()} }
+
+// list writes the items, optionally filtered, sorted and paginated:
+//
+//	/list?min=10&max=100&sort=price&order=desc&offset=20&limit=10
+//
+// min and max are inclusive and only match items in their currency.
+// sort is "item" (the default) or "price".
+func (db *database) list(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	var min, max *money
+	for _, bound := range []struct {
+		name string
+		p    **money
+	}{{"min", &min}, {"max", &max}} {
+		if s := q.Get(bound.name); s != "" {
+			m, err := parseMoney(s, "USD")
+			if err != nil {
+				httpError(w, req, http.StatusBadRequest, "%s: %v", bound.name, err)
+				return
+			}
+			*bound.p = &m
+		}
+	}
+	sortBy, order := q.Get("sort"), q.Get("order")
+	if sortBy != "" && sortBy != "item" && sortBy != "price" {
+		httpError(w, req, http.StatusBadRequest, "invalid sort: %q", sortBy)
+		return
+	}
+	if order != "" && order != "asc" && order != "desc" {
+		httpError(w, req, http.StatusBadRequest, "invalid order: %q", order)
+		return
+	}
+	offset, limit := 0, -1
+	for _, n := range []struct {
+		name string
+		p    *int
+	}{{"offset", &offset}, {"limit", &limit}} {
+		if s := q.Get(n.name); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil || v < 0 {
+				httpError(w, req, http.StatusBadRequest, "invalid %s: %q", n.name, s)
+				return
+			}
+			*n.p = v
+		}
+	}
+
+	db.mu.Lock()
+	seq := db.seq
+	var entries []entry
+	for item, price := range db.items {
+		if min != nil && (price.currency != min.currency || price.units < min.units) ||
+			max != nil && (price.currency != max.currency || price.units > max.units) {
+			continue
+		}
+		entries = append(entries, newEntry(item, price))
+	}
+	db.mu.Unlock()
+
+	format := negotiate(req)
+	w.Header().Set("Vary", "Accept")
+	if notModified(w, req, listETag(seq, format)) {
+		return
+	}
+
+	less := func(i, j int) bool { return entries[i].Item < entries[j].Item }
+	if sortBy == "price" {
+		less = func(i, j int) bool {
+			a, b := entries[i].Price, entries[j].Price
+			if a.currency != b.currency {
+				return a.currency < b.currency
+			}
+			if a.units != b.units {
+				return a.units < b.units
+			}
+			return entries[i].Item < entries[j].Item
+		}
+	}
+	if order == "desc" {
+		asc := less
+		less = func(i, j int) bool { return asc(j, i) }
+	}
+	sort.Slice(entries, less)
+
+	total := len(entries)
+	if offset > total {
+		offset = total
+	}
+	entries = entries[offset:]
+	if limit >= 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	switch format {
+	case formatJSON:
+		if entries == nil {
+			entries = []entry{}
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Items  []entry `json:"items"`
+			Total  int     `json:"total"` // matching items before pagination
+			Offset int     `json:"offset"`
+		}{entries, total, offset})
+		return
+	case formatHTML:
+		writeHTML(w, http.StatusOK, "list", rows(entries))
+		return
+	}
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s: %s\n", e.Item, e.Price)
 	}
 }
 
-func (db database) price(w http.ResponseWriter, req *http.Request) {
+// price writes the current price of an item, or with at=<time> the
+// price it had then: /price?item=socks&at=2026-10-13.
+func (db *database) price(w http.ResponseWriter, req *http.Request) {
 	item := req.URL.Query().Get("item")
-	price, ok := db[item]
+	if at := req.URL.Query().Get("at"); at != "" {
+		t, err := parseTime(at)
+		if err != nil {
+			httpError(w, req, http.StatusBadRequest, "at: %v", err)
+			return
+		}
+		db.mu.Lock()
+		price, ok := db.auditLog.priceAt(item, t)
+		db.mu.Unlock()
+		if !ok {
+			httpError(w, req, http.StatusNotFound, "no price for %q at %s", item, t.Format(time.RFC3339))
+			return
+		}
+		if wantsJSON(req) {
+			writeJSON(w, http.StatusOK, newEntry(item, price))
+			return
+		}
+		fmt.Fprintf(w, "%s\n", price)
+		return
+	}
+	db.mu.Lock()
+	price, ok := db.items[item]
+	version := db.versions[item]
+	db.mu.Unlock()
 	if !ok {
-		w.WriteHeader(http.StatusNotFound) // 404
-		fmt.Fprintf(w, "no such item: %q\n", item)
+		httpError(w, req, http.StatusNotFound, "no such item: %q", item)
+		return
+	}
+	// The text and JSON bodies of a version differ, so the tag names the
+	// format as well, and caches must also key on Accept.
+	w.Header().Set("Vary", "Accept")
+	if notModified(w, req, itemETag(version, negotiate(req))) {
+		return
+	}
+	if wantsJSON(req) {
+		writeJSON(w, http.StatusOK, newEntry(item, price))
 		return
 	}
 	fmt.Fprintf(w, "%s\n", price)
 }
+
+// create adds a new item: POST /create?item=hat&price=10.
+// The price may carry a currency, as in price=€9.50 or currency=EUR;
+// it defaults to USD.
+func (db *database) create(w http.ResponseWriter, req *http.Request) {
+	item, price, ok := itemAndPrice(w, req)
+	if !ok {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.items[item]; exists {
+		httpError(w, req, http.StatusConflict, "item already exists: %q", item)
+		return
+	}
+	if err := db.setLocked(item, price, actorOf(req)); err != nil {
+		storageError(w, req, err)
+		return
+	}
+	w.Header().Set("ETag", itemETag(db.versions[item], negotiate(req)))
+	writeEntry(w, req, http.StatusCreated, item, price)
+}
+
+// update changes the price of an existing item: POST /update?item=socks&price=6.
+// An If-Match header makes the update conditional on the item still
+// being at the version the client last saw.
+func (db *database) update(w http.ResponseWriter, req *http.Request) {
+	item, price, ok := itemAndPrice(w, req)
+	if !ok {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.items[item]; !exists {
+		httpError(w, req, http.StatusNotFound, "no such item: %q", item)
+		return
+	}
+	if !checkIfMatch(w, req, db.versions[item], itemETag) {
+		return
+	}
+	if err := db.setLocked(item, price, actorOf(req)); err != nil {
+		storageError(w, req, err)
+		return
+	}
+	w.Header().Set("ETag", itemETag(db.versions[item], negotiate(req)))
+	writeEntry(w, req, http.StatusOK, item, price)
+}
+
+// delete removes an item: POST /delete?item=socks.
+func (db *database) delete(w http.ResponseWriter, req *http.Request) {
+	if !checkPost(w, req) {
+		return
+	}
+	item := req.FormValue("item")
+	if item == "" {
+		httpError(w, req, http.StatusBadRequest, "missing item")
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, exists := db.items[item]; !exists {
+		httpError(w, req, http.StatusNotFound, "no such item: %q", item)
+		return
+	}
+	if !checkIfMatch(w, req, db.versions[item], itemETag) {
+		return
+	}
+	if err := db.deleteLocked(item, actorOf(req)); err != nil {
+		storageError(w, req, err)
+		return
+	}
+	switch negotiate(req) {
+	case formatJSON:
+		writeJSON(w, http.StatusOK, struct {
+			Deleted string `json:"deleted"`
+		}{item})
+		return
+	case formatHTML:
+		http.Redirect(w, req, "/list", http.StatusSeeOther)
+		return
+	}
+	fmt.Fprintf(w, "deleted %s\n", item)
+}
+
+// writeEntry replies with a single item after it was created or updated.
+// Browsers posting the list page's forms are sent back to the list.
+func writeEntry(w http.ResponseWriter, req *http.Request, status int, item string, price money) {
+	switch negotiate(req) {
+	case formatJSON:
+		writeJSON(w, status, newEntry(item, price))
+		return
+	case formatHTML:
+		http.Redirect(w, req, "/list", http.StatusSeeOther)
+		return
+	}
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s: %s\n", item, price)
+}
+
+// checkPost rejects requests that would change the database
+// unless they use the POST method.
+func checkPost(w http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, req, http.StatusMethodNotAllowed, "method %s not allowed", req.Method)
+		return false
+	}
+	return true
+}
+
+// itemAndPrice reads and validates the item and price parameters
+// shared by create and update, replying 400 if either is bad.
+func itemAndPrice(w http.ResponseWriter, req *http.Request) (string, money, bool) {
+	if !checkPost(w, req) {
+		return "", money{}, false
+	}
+	item := req.FormValue("item")
+	if item == "" {
+		httpError(w, req, http.StatusBadRequest, "missing item")
+		return "", money{}, false
+	}
+	cur := req.FormValue("currency")
+	if cur == "" {
+		cur = "USD"
+	}
+	price, err := parseMoney(req.FormValue("price"), strings.ToUpper(cur))
+	if err != nil {
+		httpError(w, req, http.StatusBadRequest, "%v", err)
+		return "", money{}, false
+	}
+	return item, price, true
+}
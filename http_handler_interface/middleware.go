@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"time"
+)
+
+// A middleware wraps a handler with behaviour shared by every route.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h so that mws run in the order given: the first one sees
+// the request first and the response last.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = 0
+
+const requestIDHeader = "X-Request-ID"
+
+// requestID returns the ID assigned to req by withRequestID, if any.
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// withRequestID propagates the caller's X-Request-ID, or generates a new
+// one, and echoes it in the response so logs on both sides can be joined.
+// A caller's ID is only taken if it is a plain token, so that it cannot
+// add fields of its own to the access log.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			var b [8]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(req.Context(), requestIDKey, id)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// validRequestID reports whether id is 1 to 128 letters, digits, dots,
+// underscores and hyphens.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += n
+	return n, err
+}
+
+// accessLog logs one line per request with its status and latency.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, req)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("access id=%s remote=%s method=%s path=%q status=%d bytes=%d latency=%s",
+			requestID(req), req.RemoteAddr, req.Method, req.URL.RequestURI(),
+			rec.status, rec.bytes, time.Since(start))
+	})
+}
+
+// recoverPanic turns a panicking handler into a 500 response instead of
+// a dropped connection, in the same way testrecover in func/main.go
+// stops a panic from unwinding further.
+func recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rec, ok := w.(*statusRecorder)
+		if !ok {
+			rec = &statusRecorder{ResponseWriter: w}
+		}
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err) // net/http's way of aborting a response on purpose
+				}
+				log.Printf("panic id=%s: %v\n%s", requestID(req), err, debug.Stack())
+				if rec.status == 0 {
+					httpError(rec, req, http.StatusInternalServerError, "internal server error")
+				}
+			}
+		}()
+		next.ServeHTTP(rec, req)
+	})
+}
+
+// sameOrigin refuses requests that change the database when a browser
+// says they come from another site, so a page elsewhere cannot submit
+// the list page's forms on a visiting admin's behalf. Browsers send
+// Origin with every cross-origin POST, and newer ones Sec-Fetch-Site;
+// clients such as curl send neither and are let through.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, req)
+			return
+		}
+		cross := false
+		switch req.Header.Get("Sec-Fetch-Site") {
+		case "cross-site", "same-site":
+			cross = true
+		case "same-origin", "none":
+		default:
+			if origin := req.Header.Get("Origin"); origin != "" {
+				u, err := url.Parse(origin)
+				cross = err != nil || u.Host != req.Host
+			}
+		}
+		if cross {
+			log.Printf("refused cross-origin %s %s from %q", req.Method, req.URL.Path, req.Header.Get("Origin"))
+			httpError(w, req, http.StatusForbidden, "cross-origin requests may not change prices")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	h := sameOrigin(ok)
+	tests := []struct {
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"POST", nil, http.StatusOK}, // curl and scripts
+		{"POST", map[string]string{"Origin": "http://prices.test"}, http.StatusOK},
+		{"POST", map[string]string{"Origin": "https://prices.test"}, http.StatusOK},
+		{"POST", map[string]string{"Origin": "http://evil.test"}, http.StatusForbidden},
+		{"POST", map[string]string{"Origin": "http://prices.test:8080"}, http.StatusForbidden},
+		{"POST", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"POST", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://prices.test"}, http.StatusOK},
+		{"POST", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"POST", map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"DELETE", map[string]string{"Origin": "http://evil.test"}, http.StatusForbidden},
+		{"GET", map[string]string{"Origin": "http://evil.test", "Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "http://prices.test/delete?item=socks", nil)
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.want {
+			t.Errorf("%s with %v: status %d, want %d", test.method, test.headers, rec.Code, test.want)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var got string
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = requestID(req)
+	}))
+	tests := []struct {
+		sent string
+		kept bool
+	}{
+		{"abc-123_x.y", true},
+		{strings.Repeat("a", 128), true},
+		{"", false},
+		{strings.Repeat("a", 129), false},
+		{"x status=500 remote=1.2.3.4", false},
+		{"x\ty", false},
+		{"ünïcode", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/list", nil)
+		req.Header.Set(requestIDHeader, test.sent)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if echoed := rec.Header().Get(requestIDHeader); echoed != got {
+			t.Errorf("%q: response has ID %q, request has %q", test.sent, echoed, got)
+		}
+		if kept := got == test.sent; kept != test.kept {
+			t.Errorf("%q: got ID %q, want it kept %t", test.sent, got, test.kept)
+		}
+		if !validRequestID(got) {
+			t.Errorf("%q: generated invalid ID %q", test.sent, got)
+		}
+	}
+}
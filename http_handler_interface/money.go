@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// money is an exact amount held as an integer count of the minor unit of
+// its currency (cents for USD, yen for JPY). It replaces float prices,
+// which drift as soon as they are summed or discounted.
+type money struct {
+	units    int64
+	currency string // ISO 4217 code
+}
+
+// currency describes how amounts in one ISO 4217 currency are written.
+type currency struct {
+	digits int    // digits after the decimal point
+	symbol string // prefix used when formatting, if any
+}
+
+var currencies = map[string]currency{
+	"USD": {2, "$"},
+	"EUR": {2, "€"},
+	"GBP": {2, "£"},
+	"JPY": {0, "¥"},
+	"CHF": {2, ""},
+	"CNY": {2, ""},
+}
+
+// symbols maps a leading currency symbol to its code for parsing.
+var symbols = map[string]string{"$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY"}
+
+var (
+	errCurrencyMismatch = errors.New("currency mismatch")
+	errOverflow         = errors.New("amount out of range")
+)
+
+// A roundingMode says how to round a result that falls between two
+// minor units, such as a 15% discount of $0.99.
+type roundingMode int
+
+const (
+	roundHalfEven roundingMode = iota // to nearest, ties to even (banker's rounding)
+	roundHalfUp                       // to nearest, ties away from zero
+	roundDown                         // toward zero
+	roundUp                           // away from zero
+)
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// parseMoney parses amounts such as "12.5", "$1,234.50" or "EUR 3.20".
+// A currency symbol or code in s overrides def. The amount must be
+// non-negative and must not have more decimals than the currency allows.
+func parseMoney(s, def string) (money, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	code := def
+	for sym, c := range symbols {
+		if strings.HasPrefix(s, sym) {
+			code, s = c, s[len(sym):]
+			break
+		}
+	}
+	if i := strings.IndexByte(s, ' '); i == 3 {
+		code, s = strings.ToUpper(s[:3]), s[4:]
+	}
+	cur, ok := currencies[code]
+	if !ok {
+		return money{}, fmt.Errorf("invalid price %q: unknown currency %q", orig, code)
+	}
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" && frac == "" || len(frac) > cur.digits || strings.IndexByte(frac, '.') >= 0 {
+		return money{}, fmt.Errorf("invalid price %q", orig)
+	}
+	for _, part := range []string{whole, frac} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return money{}, fmt.Errorf("invalid price %q", orig)
+			}
+		}
+	}
+	frac += strings.Repeat("0", cur.digits-len(frac))
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return money{}, fmt.Errorf("invalid price %q: %w", orig, errOverflow)
+	}
+	return money{units, code}, nil
+}
+
+// String formats m with thousands separators, e.g. "$1,234.50".
+func (m money) String() string {
+	cur := currencies[m.currency]
+	var b strings.Builder
+	u := m.units
+	if u < 0 {
+		b.WriteByte('-')
+	}
+	if cur.symbol != "" {
+		b.WriteString(cur.symbol)
+	} else {
+		b.WriteString(m.currency + " ")
+	}
+	digits := strconv.FormatUint(abs(u), 10)
+	if len(digits) <= cur.digits {
+		digits = strings.Repeat("0", cur.digits-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-cur.digits], digits[len(digits)-cur.digits:]
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if cur.digits > 0 {
+		b.WriteByte('.')
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// decimal formats m as a plain decimal without symbol or separators.
+func (m money) decimal() string {
+	cur := currencies[m.currency]
+	if cur.digits == 0 {
+		return strconv.FormatInt(m.units, 10)
+	}
+	sign := ""
+	if m.units < 0 {
+		sign = "-"
+	}
+	p := uint64(pow10(cur.digits))
+	return fmt.Sprintf("%s%d.%0*d", sign, abs(m.units)/p, cur.digits, abs(m.units)%p)
+}
+
+func abs(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+	return uint64(n)
+}
+
+func (m money) add(n money) (money, error) {
+	if m.currency != n.currency {
+		return money{}, fmt.Errorf("%s + %s: %w", m, n, errCurrencyMismatch)
+	}
+	s := m.units + n.units
+	if (s > m.units) != (n.units > 0) {
+		return money{}, fmt.Errorf("%s + %s: %w", m, n, errOverflow)
+	}
+	return money{s, m.currency}, nil
+}
+
+func (m money) sub(n money) (money, error) {
+	if m.currency != n.currency {
+		return money{}, fmt.Errorf("%s - %s: %w", m, n, errCurrencyMismatch)
+	}
+	d := m.units - n.units
+	if (d < m.units) != (n.units > 0) {
+		return money{}, fmt.Errorf("%s - %s: %w", m, n, errOverflow)
+	}
+	return money{d, m.currency}, nil
+}
+
+// mul returns m times a whole quantity.
+func (m money) mul(qty int64) (money, error) {
+	return m.scale(qty, 1, roundHalfEven)
+}
+
+// scale returns m*num/den rounded with mode, e.g. m.scale(85, 100, mode)
+// for a 15% discount.
+func (m money) scale(num, den int64, mode roundingMode) (money, error) {
+	if den == 0 {
+		return money{}, errors.New("scale: zero denominator")
+	}
+	p := new(big.Int).Mul(big.NewInt(m.units), big.NewInt(num))
+	d := big.NewInt(den)
+	if d.Sign() < 0 {
+		p.Neg(p)
+		d.Neg(d)
+	}
+	q, r := new(big.Int).QuoRem(p, d, new(big.Int))
+	if r.Sign() != 0 {
+		// Compare 2|r| with den to find which side of the midpoint we are.
+		cmp := new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(d)
+		away := false
+		switch mode {
+		case roundHalfEven:
+			away = cmp > 0 || cmp == 0 && q.Bit(0) == 1
+		case roundHalfUp:
+			away = cmp >= 0
+		case roundUp:
+			away = true
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+	}
+	if !q.IsInt64() {
+		return money{}, fmt.Errorf("%s * %d/%d: %w", m, num, den, errOverflow)
+	}
+	return money{q.Int64(), m.currency}, nil
+}
+
+// moneyJSON is the wire form of money. The amount is a decimal string so
+// that clients never see it as a float.
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{m.decimal(), m.currency})
+}
+
+// UnmarshalJSON accepts the object form or a string such as "$1,234.50".
+// Numbers are refused: they are floats to encoding/json.
+func (m *money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	var (
+		v   money
+		err error
+	)
+	switch {
+	case len(data) > 0 && data[0] == '{':
+		var j moneyJSON
+		if err := json.Unmarshal(data, &j); err != nil {
+			return err
+		}
+		// parseMoney only takes prices; totals may be negative here.
+		amount := strings.TrimPrefix(j.Amount, "-")
+		v, err = parseMoney(amount, j.Currency)
+		if amount != j.Amount {
+			v.units = -v.units
+		}
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		v, err = parseMoney(s, "USD")
+	default:
+		return fmt.Errorf("invalid money %s: want an object or a string", data)
+	}
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
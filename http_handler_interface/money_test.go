@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+// codes lists the known currencies in a fixed order, so quick can pick
+// one by index.
+var codes = func() []string {
+	var list []string
+	for code := range currencies {
+		list = append(list, code)
+	}
+	sort.Strings(list)
+	return list
+}()
+
+func pick(i uint8) string { return codes[int(i)%len(codes)] }
+
+func TestParseFormatRoundTrip(t *testing.T) {
+	f := func(units int64, cur uint8) bool {
+		m := money{units & math.MaxInt64, pick(cur)}
+		for _, s := range []string{m.String(), m.decimal()} {
+			got, err := parseMoney(s, m.currency)
+			if err != nil || got != m {
+				t.Logf("parseMoney(%q) = %v, %v, want %v", s, got, err, m)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	f := func(units int64, cur uint8) bool {
+		m := money{units, pick(cur)}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+		var got money
+		if err := json.Unmarshal(data, &got); err != nil || got != m {
+			t.Logf("%s unmarshals to %v, %v, want %v", data, got, err, m)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want money
+	}{
+		{`{"amount": "12.50", "currency": "EUR"}`, money{1250, "EUR"}},
+		{`{"amount": "-3.00", "currency": "USD"}`, money{-300, "USD"}},
+		{`"$1,234.50"`, money{123450, "USD"}},
+	}
+	for _, test := range tests {
+		var got money
+		if err := json.Unmarshal([]byte(test.in), &got); err != nil || got != test.want {
+			t.Errorf("unmarshal %s = %v, %v, want %v", test.in, got, err, test.want)
+		}
+	}
+	for _, in := range []string{`12.5`, `true`, `{"amount": "x", "currency": "USD"}`} {
+		var got money
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("unmarshal %s = %v, want error", in, got)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want money
+	}{
+		{"12.5", money{1250, "USD"}},
+		{"$1,234.50", money{123450, "USD"}},
+		{"EUR 3.20", money{320, "EUR"}},
+		{"¥500", money{500, "JPY"}},
+		{".5", money{50, "USD"}},
+	}
+	for _, test := range tests {
+		if got, err := parseMoney(test.in, "USD"); err != nil || got != test.want {
+			t.Errorf("parseMoney(%q) = %v, %v, want %v", test.in, got, err, test.want)
+		}
+	}
+	for _, in := range []string{"", ".", "-1", "1.234", "¥1.5", "XYZ 1", "1.2.3", "1e3", "92233720368547758.08"} {
+		if got, err := parseMoney(in, "USD"); err == nil {
+			t.Errorf("parseMoney(%q) = %v, want error", in, got)
+		}
+	}
+}
+
+func TestAddSub(t *testing.T) {
+	f := func(a, b int64) bool {
+		x, y := money{a, "USD"}, money{b, "USD"}
+		sum := new(big.Int).Add(big.NewInt(a), big.NewInt(b))
+		got, err := x.add(y)
+		if sum.IsInt64() != (err == nil) || err == nil && got.units != sum.Int64() {
+			t.Logf("%d + %d = %v, %v", a, b, got, err)
+			return false
+		}
+		if err != nil && !errors.Is(err, errOverflow) {
+			return false
+		}
+		diff := new(big.Int).Sub(big.NewInt(a), big.NewInt(b))
+		got, err = x.sub(y)
+		if diff.IsInt64() != (err == nil) || err == nil && got.units != diff.Int64() {
+			t.Logf("%d - %d = %v, %v", a, b, got, err)
+			return false
+		}
+		return err == nil || errors.Is(err, errOverflow)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+	// quick rarely lands near the ends of the range, where overflow is.
+	for _, c := range [][2]int64{
+		{math.MaxInt64, 1}, {math.MinInt64, -1}, {math.MinInt64, math.MinInt64},
+		{math.MaxInt64, math.MinInt64}, {0, math.MinInt64}, {-1, math.MinInt64}, {math.MaxInt64, 0},
+	} {
+		if !f(c[0], c[1]) || !f(c[1], c[0]) {
+			t.Errorf("add/sub wrong at %d, %d", c[0], c[1])
+		}
+	}
+	if _, err := (money{1, "USD"}).add(money{1, "EUR"}); !errors.Is(err, errCurrencyMismatch) {
+		t.Errorf("USD + EUR error = %v, want errCurrencyMismatch", err)
+	}
+}
+
+func TestScale(t *testing.T) {
+	modes := []struct {
+		name string
+		mode roundingMode
+	}{
+		{"half-even", roundHalfEven},
+		{"half-up", roundHalfUp},
+		{"down", roundDown},
+		{"up", roundUp},
+	}
+	for _, m := range modes {
+		t.Run(m.name, func(t *testing.T) {
+			f := func(units int64, num, den int32) bool {
+				if den == 0 {
+					den = 1
+				}
+				got, err := money{units, "USD"}.scale(int64(num), int64(den), m.mode)
+				want, ok := reference(units, int64(num), int64(den), m.mode)
+				if !ok {
+					return errors.Is(err, errOverflow)
+				}
+				if err != nil || got.units != want {
+					t.Logf("%d * %d/%d = %v, %v, want %d", units, num, den, got, err, want)
+					return false
+				}
+				return true
+			}
+			if err := quick.Check(f, nil); err != nil {
+				t.Error(err)
+			}
+			// Small values hit every kind of tie and remainder.
+			for units := int64(-25); units <= 25; units++ {
+				for _, den := range []int32{-4, -3, -2, 2, 3, 4, 10} {
+					if !f(units, 1, den) {
+						t.Errorf("%d / %d wrong", units, den)
+					}
+				}
+			}
+		})
+	}
+}
+
+// reference computes units*num/den rounded with mode using exact
+// rational arithmetic, independently of scale.
+func reference(units, num, den int64, mode roundingMode) (int64, bool) {
+	exact := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(units), big.NewInt(num)), big.NewInt(den))
+	neg := exact.Sign() < 0
+	mag := new(big.Rat).Abs(exact)
+	floor := new(big.Int).Quo(mag.Num(), mag.Denom()) // toward zero, on the magnitude
+	frac := new(big.Rat).Sub(mag, new(big.Rat).SetInt(floor))
+	half := big.NewRat(1, 2)
+	up := false
+	switch mode {
+	case roundHalfEven:
+		c := frac.Cmp(half)
+		up = c > 0 || c == 0 && floor.Bit(0) == 1
+	case roundHalfUp:
+		up = frac.Cmp(half) >= 0
+	case roundUp:
+		up = frac.Sign() > 0
+	}
+	if up {
+		floor.Add(floor, big.NewInt(1))
+	}
+	if neg {
+		floor.Neg(floor)
+	}
+	return floor.Int64(), floor.IsInt64()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// stockLevel reports or sets how many units of an item are in stock:
+//
+//	GET  /stock?item=socks
+//	POST /stock?item=socks&qty=40
+func (db *database) stockLevel(w http.ResponseWriter, req *http.Request) {
+	item := req.FormValue("item")
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.items[item]; !ok {
+		httpError(w, req, http.StatusNotFound, "no such item: %q", item)
+		return
+	}
+	if req.Method == http.MethodPost {
+		if !checkIfMatch(w, req, db.stockVersions[item], stockETag) {
+			return
+		}
+		qty, err := strconv.ParseInt(req.FormValue("qty"), 10, 64)
+		if err != nil || qty < 0 {
+			httpError(w, req, http.StatusBadRequest, "invalid qty: %q", req.FormValue("qty"))
+			return
+		}
+		if err := db.applyLocked(record{Op: "stock", Item: item, Qty: qty}, actorOf(req)); err != nil {
+			storageError(w, req, err)
+			return
+		}
+	}
+	qty := db.stock[item]
+	etag := stockETag(db.stockVersions[item], negotiate(req))
+	w.Header().Set("Vary", "Accept")
+	if req.Method == http.MethodPost {
+		w.Header().Set("ETag", etag)
+	} else if notModified(w, req, etag) {
+		return
+	}
+	if wantsJSON(req) {
+		writeJSON(w, http.StatusOK, orderLine{item, qty})
+		return
+	}
+	fmt.Fprintf(w, "%s: %d in stock\n", item, qty)
+}
+
+// A receiptLine is one itemized line of a placed order.
+type receiptLine struct {
+	Item      string `json:"item"`
+	Qty       int64  `json:"qty"`
+	UnitPrice money  `json:"unit_price"`
+	Total     money  `json:"total"`
+}
+
+// order places an order for one or more items, taking all of them from
+// stock or none:
+//
+//	POST /order?item=socks&qty=2&item=shoes&qty=1
+//	POST /order  {"lines": [{"item": "socks", "qty": 2}]}
+//
+// The whole check-and-decrement happens under db.mu and is logged as a
+// single record, so concurrent orders can never oversell an item.
+func (db *database) order(w http.ResponseWriter, req *http.Request) {
+	if !checkPost(w, req) {
+		return
+	}
+	lines, err := orderLines(req)
+	if err != nil {
+		httpError(w, req, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	want := make(map[string]int64) // an item may appear on several lines
+	var receipt []receiptLine
+	var total money
+	for i, l := range lines {
+		price, ok := db.items[l.Item]
+		if !ok {
+			httpError(w, req, http.StatusNotFound, "no such item: %q", l.Item)
+			return
+		}
+		// Compare against what is left rather than summing, which
+		// could overflow and let a huge order through.
+		if left := db.stock[l.Item] - want[l.Item]; l.Qty > left {
+			httpError(w, req, http.StatusConflict, "insufficient stock for %q: %d requested, %d available",
+				l.Item, l.Qty, left)
+			return
+		}
+		want[l.Item] += l.Qty
+		lineTotal, err := price.mul(l.Qty)
+		if err == nil && i == 0 {
+			total = lineTotal
+		} else if err == nil {
+			total, err = total.add(lineTotal)
+		}
+		if err != nil {
+			httpError(w, req, http.StatusBadRequest, "%v", err)
+			return
+		}
+		receipt = append(receipt, receiptLine{l.Item, l.Qty, price, lineTotal})
+	}
+	if err := db.applyLocked(record{Op: "order", Lines: lines}, actorOf(req)); err != nil {
+		storageError(w, req, err)
+		return
+	}
+
+	if wantsJSON(req) {
+		writeJSON(w, http.StatusOK, struct {
+			Lines []receiptLine `json:"lines"`
+			Total money         `json:"total"`
+		}{receipt, total})
+		return
+	}
+	for _, r := range receipt {
+		fmt.Fprintf(w, "%s x %d @ %s = %s\n", r.Item, r.Qty, r.UnitPrice, r.Total)
+	}
+	fmt.Fprintf(w, "total: %s\n", total)
+}
+
+// orderLines reads the lines of an order from a JSON body or from
+// repeated item and qty form values.
+func orderLines(req *http.Request) ([]orderLine, error) {
+	var lines []orderLine
+	if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt == "application/json" {
+		var body struct {
+			Lines []orderLine `json:"lines"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid order: %v", err)
+		}
+		lines = body.Lines
+	} else {
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+		items, qtys := req.Form["item"], req.Form["qty"]
+		if len(items) != len(qtys) {
+			return nil, fmt.Errorf("invalid order: %d items but %d quantities", len(items), len(qtys))
+		}
+		for i := range items {
+			qty, err := strconv.ParseInt(qtys[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid qty: %q", qtys[i])
+			}
+			lines = append(lines, orderLine{items[i], qty})
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("empty order")
+	}
+	for _, l := range lines {
+		if l.Item == "" || l.Qty <= 0 {
+			return nil, fmt.Errorf("invalid order line: item %q, qty %d", l.Item, l.Qty)
+		}
+	}
+	return lines, nil
+}
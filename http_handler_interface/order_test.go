@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestDB returns a database in a temporary directory holding items
+// at the given prices, in cents, with the given stock.
+func newTestDB(t *testing.T, items map[string][2]int64) *database {
+	t.Helper()
+	dir := t.TempDir()
+	s, st, _, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := openAudit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &database{state: st, store: s, auditLog: al}
+	t.Cleanup(db.close)
+	for item, v := range items {
+		if err := db.set(item, money{v[0], "USD"}, v[1], system); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// post sends a form POST to h and returns the response.
+func post(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestParallelOrders(t *testing.T) {
+	const stock, buyers = 100, 150
+	db := newTestDB(t, map[string][2]int64{"socks": {500, stock}})
+	srv := httptest.NewServer(http.HandlerFunc(db.order))
+	defer srv.Close()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = make(map[int]int)
+	)
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.PostForm(srv.URL, url.Values{"item": {"socks"}, "qty": {"1"}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			mu.Lock()
+			statuses[resp.StatusCode]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if statuses[http.StatusOK] != stock || statuses[http.StatusConflict] != buyers-stock {
+		t.Errorf("statuses %v, want %d OK and %d Conflict", statuses, stock, buyers-stock)
+	}
+	if got := db.stock["socks"]; got != 0 {
+		t.Errorf("stock left = %d, want 0", got)
+	}
+}
+
+func TestOrderOverflow(t *testing.T) {
+	db := newTestDB(t, map[string][2]int64{"sample": {0, 5}})
+	rec := post(http.HandlerFunc(db.order), "/order", url.Values{
+		"item": {"sample", "sample"},
+		"qty":  {"5", "9223372036854775807"},
+	})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if got := db.stock["sample"]; got != 5 {
+		t.Errorf("stock = %d, want 5", got)
+	}
+}
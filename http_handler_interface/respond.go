@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response formats chosen by negotiate.
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatHTML = "html"
+)
+
+// negotiate picks the response format the client's Accept header
+// prefers. Plain text stays the default, so requests without an Accept
+// header, or with */*, get text.
+func negotiate(req *http.Request) string {
+	q := map[string]float64{formatText: -1, formatJSON: -1, formatHTML: -1}
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		v := 1.0
+		if s, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				v = f
+			}
+		}
+		var f string
+		switch mt {
+		case "application/json":
+			f = formatJSON
+		case "text/html":
+			f = formatHTML
+		case "text/plain", "text/*", "*/*":
+			f = formatText
+		default:
+			continue
+		}
+		if v > q[f] {
+			q[f] = v
+		}
+	}
+	best := formatText
+	for _, f := range []string{formatHTML, formatJSON} {
+		if q[f] > 0 && q[f] > q[best] {
+			best = f
+		}
+	}
+	return best
+}
+
+// wantsJSON reports whether the client prefers application/json.
+func wantsJSON(req *http.Request) bool { return negotiate(req) == formatJSON }
+
+// writeJSON writes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+// errorEnvelope is the JSON body of every error response:
+//
+//	{"error": {"status": 404, "code": "not_found", "message": "no such item: \"hat\""}}
+//
+// code is derived from the HTTP status and is stable across releases;
+// message is meant for people and may change.
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// httpError replies with status and a message, as text or as an error
+// envelope depending on what the client accepts.
+func httpError(w http.ResponseWriter, req *http.Request, status int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	switch negotiate(req) {
+	case formatJSON:
+		writeJSON(w, status, errorEnvelope{errorBody{status, errorCode(status), msg}})
+		return
+	case formatHTML:
+		writeHTML(w, status, "error", errorBody{status, http.StatusText(status), msg})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, msg)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// envString and envDuration return the value of the environment
+// variable key, or def if it is unset, so every flag can also be set
+// from the environment; an explicit flag still wins.
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s: %v", key, err)
+	}
+	return d
+}
+
+// serve runs srv until it fails or the process receives SIGINT or
+// SIGTERM. On a signal it stops accepting connections and waits up to
+// grace for in-flight requests to finish before returning.
+func serve(srv *http.Server, certFile, keyFile string, grace time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" || keyFile != "" {
+			log.Printf("listening on https://%s", srv.Addr)
+			errc <- srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			log.Printf("listening on http://%s", srv.Addr)
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process the usual way
+	log.Printf("shutting down, draining requests for up to %s", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
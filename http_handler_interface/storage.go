@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// A store keeps the database durable as a snapshot of all items plus an
+// append-only write-ahead log of the changes made since that snapshot.
+//
+// Each log record is framed as
+//
+//	length uint32 | crc32 uint32 | JSON payload
+//
+// so recovery can tell a complete record from one torn by a crash
+// halfway through a write. A torn or corrupt record ends the log; it and
+// anything after it is truncated away on open.
+type store struct {
+	dir  string
+	wal  *os.File
+	size int64 // offset just past the last complete record in wal
+}
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+	headerSize   = 8
+	maxRecord    = 1 << 20 // larger lengths can only come from a torn header
+
+	snapshotVersion = 1 // of the snapshot format; bump when it changes
+)
+
+// state is everything the store persists: the price and the stock
+// level of each item, and the versions of each for ETags.
+//
+// seq counts every change ever applied; an item's version is the seq of
+// the last change to its price, and its stock version that of the last
+// change to its stock, so that a sale does not make an edit of the price
+// fail its If-Match. Versions are never reused, not even when an item is
+// deleted and created again, so a stale ETag can never match.
+type state struct {
+	items         map[string]money
+	stock         map[string]int64
+	versions      map[string]uint64
+	stockVersions map[string]uint64
+	seq           uint64
+}
+
+func newState() state {
+	return state{
+		items:         make(map[string]money),
+		stock:         make(map[string]int64),
+		versions:      make(map[string]uint64),
+		stockVersions: make(map[string]uint64),
+	}
+}
+
+// snapshotData is the on-disk form of a state.
+type snapshotData struct {
+	Version       int               `json:"version"` // snapshotVersion
+	Items         map[string]money  `json:"items"`
+	Stock         map[string]int64  `json:"stock"`
+	Versions      map[string]uint64 `json:"versions"`
+	StockVersions map[string]uint64 `json:"stock_versions"`
+	Seq           uint64            `json:"seq"`
+}
+
+func decodeSnapshot(data []byte, st *state) error {
+	snap := snapshotData{Items: st.items, Stock: st.stock, Versions: st.versions, StockVersions: st.stockVersions}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unknown snapshot version %d, want %d", snap.Version, snapshotVersion)
+	}
+	st.seq = snap.Seq
+	return nil
+}
+
+// A record is one logged change to the database. Seq is the state's
+// seq once the record is applied; replay skips records the snapshot
+// already holds.
+type record struct {
+	Seq   uint64      `json:"seq"`
+	Op    string      `json:"op"` // "set", "delete", "stock" or "order"
+	Item  string      `json:"item,omitempty"`
+	Price *money      `json:"price,omitempty"`
+	Qty   int64       `json:"qty,omitempty"`   // new stock level for "stock"
+	Lines []orderLine `json:"lines,omitempty"` // quantities taken by "order"
+}
+
+// An orderLine asks for qty units of item.
+type orderLine struct {
+	Item string `json:"item"`
+	Qty  int64  `json:"qty"`
+}
+
+// openStore opens the store in dir, creating it if needed, and returns
+// the state recovered from the last snapshot and the log. fresh reports
+// whether dir held no data at all.
+func openStore(dir string) (s *store, st state, fresh bool, err error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, st, false, err
+	}
+	st = newState()
+	snap, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	switch {
+	case err == nil:
+		if err := decodeSnapshot(snap, &st); err != nil {
+			return nil, st, false, fmt.Errorf("reading snapshot: %v", err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		fresh = true
+	default:
+		return nil, st, false, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, st, false, err
+	}
+	n, good, err := replay(wal, &st)
+	if err != nil {
+		wal.Close()
+		return nil, st, false, fmt.Errorf("replaying log: %v", err)
+	}
+	if n > 0 {
+		fresh = false
+	}
+	// Drop a torn final record so new records follow the last good one.
+	if err := wal.Truncate(good); err != nil {
+		wal.Close()
+		return nil, st, false, err
+	}
+	if _, err := wal.Seek(good, io.SeekStart); err != nil {
+		wal.Close()
+		return nil, st, false, err
+	}
+	return &store{dir: dir, wal: wal, size: good}, st, fresh, nil
+}
+
+// replay applies every complete record in r to st. It returns the
+// number of records applied and the offset just past the last of them.
+func replay(r io.Reader, st *state) (n int, good int64, err error) {
+	in := bufio.NewReader(r)
+	var hdr [headerSize]byte
+	for {
+		if _, err := io.ReadFull(in, hdr[:]); err != nil {
+			if err != io.EOF {
+				log.Printf("wal: torn record header at offset %d, truncating", good)
+			}
+			return n, good, nil
+		}
+		size := binary.LittleEndian.Uint32(hdr[0:4])
+		sum := binary.LittleEndian.Uint32(hdr[4:8])
+		if size > maxRecord {
+			log.Printf("wal: bad record length at offset %d, truncating", good)
+			return n, good, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			log.Printf("wal: torn record at offset %d, truncating", good)
+			return n, good, nil
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			log.Printf("wal: checksum mismatch at offset %d, truncating", good)
+			return n, good, nil
+		}
+		var rec record
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return n, good, fmt.Errorf("record at offset %d: %v", good, err)
+		}
+		if rec.Seq > st.seq {
+			rec.apply(st)
+		}
+		n++
+		good += headerSize + int64(size)
+	}
+}
+
+func (rec record) apply(st *state) {
+	st.seq++
+	switch rec.Op {
+	case "set":
+		st.items[rec.Item] = *rec.Price
+		st.versions[rec.Item] = st.seq
+	case "delete":
+		delete(st.items, rec.Item)
+		delete(st.stock, rec.Item)
+		delete(st.versions, rec.Item)
+		delete(st.stockVersions, rec.Item)
+	case "stock":
+		st.stock[rec.Item] = rec.Qty
+		st.stockVersions[rec.Item] = st.seq
+	case "order":
+		for _, l := range rec.Lines {
+			st.stock[l.Item] -= l.Qty
+			st.stockVersions[l.Item] = st.seq
+		}
+	}
+}
+
+// append writes rec to the log and syncs it to disk. If that fails, the
+// log is cut back to its last complete record, so that records appended
+// later are not stranded behind a torn one that replay stops at.
+func (s *store) append(rec record) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[headerSize:], payload)
+	_, err = s.wal.Write(buf)
+	if err == nil {
+		err = s.wal.Sync()
+	}
+	if err != nil {
+		if terr := s.wal.Truncate(s.size); terr != nil {
+			return fmt.Errorf("%v; truncating log: %v", err, terr)
+		}
+		if _, serr := s.wal.Seek(s.size, io.SeekStart); serr != nil {
+			return fmt.Errorf("%v; seeking log: %v", err, serr)
+		}
+		return err
+	}
+	s.size += int64(len(buf))
+	return nil
+}
+
+// snapshot atomically replaces the snapshot with st and empties the
+// log. A crash between the two steps is harmless: replay skips the old
+// log's records, whose seq the new snapshot already covers. Replaying
+// an order twice would take its stock twice.
+func (s *store) snapshot(st state) error {
+	data, err := json.Marshal(snapshotData{snapshotVersion, st.items, st.stock, st.versions, st.stockVersions, st.seq})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if d, err := os.Open(s.dir); err == nil {
+		d.Sync() // make the rename durable; not supported everywhere
+		d.Close()
+	}
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	s.size = 0
+	_, err = s.wal.Seek(0, io.SeekStart)
+	return err
+}
+
+func (s *store) close() error { return s.wal.Close() }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func usd(cents int64) *money { return &money{cents, "USD"} }
+
+// testRecords are three changes, each touching a different item, so a
+// recovered state shows exactly which of them survived.
+var testRecords = []record{
+	{Seq: 1, Op: "set", Item: "shoes", Price: usd(5000)},
+	{Seq: 2, Op: "set", Item: "socks", Price: usd(500)},
+	{Seq: 3, Op: "set", Item: "hats", Price: usd(1500)},
+}
+
+// stateOf returns the state recs produce on an empty store.
+func stateOf(recs ...record) state {
+	st := newState()
+	for _, rec := range recs {
+		rec.apply(&st)
+	}
+	return st
+}
+
+func mustOpen(t *testing.T, dir string) (*store, state) {
+	t.Helper()
+	s, st, _, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("openStore: %v", err)
+	}
+	t.Cleanup(func() { s.close() })
+	return s, st
+}
+
+func mustAppend(t *testing.T, s *store, recs ...record) {
+	t.Helper()
+	for _, rec := range recs {
+		if err := s.append(rec); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+}
+
+func walSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	fi, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := mustOpen(t, dir)
+	recs := append(testRecords,
+		record{Seq: 4, Op: "stock", Item: "socks", Qty: 100},
+		record{Seq: 5, Op: "order", Lines: []orderLine{{"socks", 2}}},
+		record{Seq: 6, Op: "delete", Item: "hats"})
+	mustAppend(t, s, recs...)
+	s.close()
+
+	_, st := mustOpen(t, dir)
+	if want := stateOf(recs...); !reflect.DeepEqual(st, want) {
+		t.Errorf("recovered %+v, want %+v", st, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := mustOpen(t, dir)
+	mustAppend(t, s, testRecords[:2]...)
+	if err := s.snapshot(stateOf(testRecords[:2]...)); err != nil {
+		t.Fatal(err)
+	}
+	if n := walSize(t, dir); n != 0 {
+		t.Errorf("log holds %d bytes after snapshot, want 0", n)
+	}
+	mustAppend(t, s, testRecords[2])
+	s.close()
+
+	_, st := mustOpen(t, dir)
+	if want := stateOf(testRecords...); !reflect.DeepEqual(st, want) {
+		t.Errorf("recovered %+v, want %+v", st, want)
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	for _, data := range []string{`{"shoes": "$50.00"}`, `{"version": 99, "items": {}}`} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if s, _, _, err := openStore(dir); err == nil {
+			s.close()
+			t.Errorf("opened store with snapshot %s, want error", data)
+		}
+	}
+}
+
+// TestSnapshotCrash simulates a crash after a snapshot was renamed into
+// place but before the log was emptied: the old log must not be
+// applied a second time on top of the snapshot.
+func TestSnapshotCrash(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := mustOpen(t, dir)
+	recs := []record{
+		{Seq: 1, Op: "set", Item: "socks", Price: usd(500)},
+		{Seq: 2, Op: "stock", Item: "socks", Qty: 100},
+		{Seq: 3, Op: "order", Lines: []orderLine{{"socks", 2}}},
+	}
+	mustAppend(t, s, recs...)
+	path := filepath.Join(dir, walFile)
+	oldLog, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.snapshot(stateOf(recs...)); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+	if err := os.WriteFile(path, oldLog, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, st := mustOpen(t, dir)
+	if got := st.stock["socks"]; got != 98 {
+		t.Errorf("socks stock = %d after recovery, want 98", got)
+	}
+	if want := stateOf(recs...); !reflect.DeepEqual(st, want) {
+		t.Errorf("recovered %+v, want %+v", st, want)
+	}
+}
+
+// TestRecoverDamagedTail damages the last of three records in each way
+// a crash or a bad disk can, and checks that recovery keeps the first
+// two, cuts the log back to them, and appends cleanly after them.
+func TestRecoverDamagedTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(wal []byte, last int) []byte // last is the offset of the third record
+	}{
+		{"torn header", func(wal []byte, last int) []byte { return wal[:last+headerSize/2] }},
+		{"header only", func(wal []byte, last int) []byte { return wal[:last+headerSize] }},
+		{"torn payload", func(wal []byte, last int) []byte { return wal[:len(wal)-3] }},
+		{"checksum mismatch", func(wal []byte, last int) []byte {
+			wal[len(wal)-2] ^= 0xff
+			return wal
+		}},
+		{"bad length", func(wal []byte, last int) []byte {
+			wal[last+3] = 0xff
+			return wal
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, _ := mustOpen(t, dir)
+			mustAppend(t, s, testRecords[:2]...)
+			last := int(walSize(t, dir))
+			mustAppend(t, s, testRecords[2])
+			s.close()
+
+			path := filepath.Join(dir, walFile)
+			wal, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, test.damage(wal, last), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s, st := mustOpen(t, dir)
+			if want := stateOf(testRecords[:2]...); !reflect.DeepEqual(st, want) {
+				t.Errorf("recovered %+v, want %+v", st, want)
+			}
+			if n := walSize(t, dir); n != int64(last) {
+				t.Errorf("log is %d bytes after recovery, want %d", n, last)
+			}
+			extra := record{Seq: 3, Op: "set", Item: "gloves", Price: usd(900)}
+			mustAppend(t, s, extra)
+			s.close()
+			_, st = mustOpen(t, dir)
+			if want := stateOf(testRecords[0], testRecords[1], extra); !reflect.DeepEqual(st, want) {
+				t.Errorf("after appending, recovered %+v, want %+v", st, want)
+			}
+		})
+	}
+}
+
+// TestKill runs a writer in a child process, kills it while it is
+// appending, and checks that every record it reported as written
+// survived.
+func TestKill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a child process")
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestKillWriter$")
+	cmd.Env = append(os.Environ(), "PRICEDB_TEST_WRITER="+dir)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	acked := -1
+	lines := bufio.NewScanner(out)
+	for acked < 200 && lines.Scan() {
+		if n, err := strconv.Atoi(lines.Text()); err == nil {
+			acked = n
+		}
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	if acked < 0 {
+		t.Fatal("writer acknowledged nothing")
+	}
+
+	_, st := mustOpen(t, dir)
+	for i := 0; i <= acked; i++ {
+		if _, ok := st.items[fmt.Sprint("item", i)]; !ok {
+			t.Fatalf("item%d was acknowledged but not recovered", i)
+		}
+	}
+}
+
+// TestKillWriter is the child process of TestKill. It appends records
+// until it is killed, printing the number of each once it is synced.
+func TestKillWriter(t *testing.T) {
+	dir := os.Getenv("PRICEDB_TEST_WRITER")
+	if dir == "" {
+		t.Skip("only run by TestKill")
+	}
+	s, _, _, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if err := s.append(record{Seq: uint64(i) + 1, Op: "set", Item: fmt.Sprint("item", i), Price: usd(int64(i))}); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println(i)
+	}
+}
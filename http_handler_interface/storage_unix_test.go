@@ -0,0 +1,56 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// TestAppendFailure makes an append fail halfway through its write by
+// lowering the file size limit in a child process, then checks that a
+// record appended after the failure is not lost behind the torn one.
+func TestAppendFailure(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestAppendFailureWriter$")
+	cmd.Env = append(os.Environ(), "PRICEDB_TEST_WRITER="+dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("writer: %v\n%s", err, out)
+	}
+	_, st := mustOpen(t, dir)
+	if want := stateOf(testRecords[0], testRecords[2]); !reflect.DeepEqual(st, want) {
+		t.Errorf("recovered %+v, want %+v", st, want)
+	}
+}
+
+// TestAppendFailureWriter is the child process of TestAppendFailure.
+func TestAppendFailureWriter(t *testing.T) {
+	dir := os.Getenv("PRICEDB_TEST_WRITER")
+	if dir == "" {
+		t.Skip("only run by TestAppendFailure")
+	}
+	signal.Ignore(syscall.SIGXFSZ)
+	s, _ := mustOpen(t, dir)
+	mustAppend(t, s, testRecords[0])
+
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &lim); err != nil {
+		t.Fatal(err)
+	}
+	small := lim
+	small.Cur = uint64(s.size) + headerSize + 4 // room for a torn record only
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &small); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.append(testRecords[1]); err == nil {
+		t.Fatal("append beyond the file size limit succeeded")
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &lim); err != nil {
+		t.Fatal(err)
+	}
+	mustAppend(t, s, testRecords[2])
+}
@@ -0,0 +1,208 @@
+// Cf converts temperatures given as arguments, as lines on standard input,
+// or as a named column of a CSV file.
+//
+//	cf -to F 37C 310K
+//	sensor-dump | cf -to K -from C
+//	cf -csv export.csv -col temp -from F -to C -prec 2 > converted.csv
+//
+// Values without a unit suffix are read in the -from scale. Bad input is
+// reported on standard error with its line number and does not stop the
+// conversion; the exit status is 1 if any value could not be converted.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"tempconv"
+)
+
+var (
+	to     = flag.String("to", "F", "target unit: C, F, K or R")
+	from   = flag.String("from", "C", "unit of values written without a suffix")
+	prec   = flag.Int("prec", -1, "digits after the decimal point (-1 for the shortest exact form)")
+	format = flag.String("format", "text", "output format for arguments and stdin: text, value or json")
+	csvIn  = flag.String("csv", "", "convert a column of this CSV file (use - for stdin)")
+	col    = flag.String("col", "", "name of the CSV column to convert")
+)
+
+// units maps a -to value to the parser producing it and its display suffix.
+var units = map[string]struct {
+	parse  func(string) (float64, error)
+	suffix string
+}{
+	"C": {func(s string) (float64, error) { c, err := tempconv.ParseCelsius(s); return float64(c), err }, "°C"},
+	"F": {func(s string) (float64, error) { f, err := tempconv.ParseFahrenheit(s); return float64(f), err }, "°F"},
+	"K": {func(s string) (float64, error) { k, err := tempconv.ParseKelvin(s); return float64(k), err }, "K"},
+	"R": {func(s string) (float64, error) { r, err := tempconv.ParseRankine(s); return float64(r), err }, "°R"},
+}
+
+var failed bool // set when any value could not be converted
+
+func main() {
+	flag.Parse()
+	*to = strings.ToUpper(*to)
+	*from = strings.ToUpper(*from)
+	if _, ok := units[*to]; !ok {
+		fatalf("unknown target unit %q", *to)
+	}
+	if _, ok := units[*from]; !ok {
+		fatalf("unknown source unit %q", *from)
+	}
+	switch *format {
+	case "text", "value", "json":
+	default:
+		fatalf("unknown format %q", *format)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	switch {
+	case *csvIn != "":
+		if *col == "" {
+			fatalf("-csv requires -col")
+		}
+		convertCSV(out, *csvIn, *col)
+	case flag.NArg() > 0:
+		for i, arg := range flag.Args() {
+			convertLine(out, fmt.Sprintf("arg %d", i+1), arg)
+		}
+	default:
+		input := bufio.NewScanner(os.Stdin)
+		for n := 1; input.Scan(); n++ {
+			line := strings.TrimSpace(input.Text())
+			if line == "" {
+				continue
+			}
+			convertLine(out, fmt.Sprintf("line %d", n), line)
+		}
+		if err := input.Err(); err != nil {
+			fatalf("reading stdin: %v", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		fatalf("%v", err)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// convert parses s, falling back to the -from unit when s has no
+// suffix, and returns its value in the -to unit.
+func convert(s string) (float64, error) {
+	if _, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		s += *from
+	}
+	return units[*to].parse(s)
+}
+
+func formatValue(v float64) string {
+	if *prec < 0 {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', *prec, 64)
+}
+
+func convertLine(w io.Writer, where, s string) {
+	v, err := convert(s)
+	if err != nil {
+		reportf("%s: %v", where, err)
+		return
+	}
+	num := formatValue(v)
+	switch *format {
+	case "text":
+		fmt.Fprintf(w, "%s = %s%s\n", s, num, units[*to].suffix)
+	case "value":
+		fmt.Fprintln(w, num)
+	case "json":
+		b, _ := json.Marshal(struct {
+			Input string      `json:"input"`
+			Value json.Number `json:"value"`
+			Unit  string      `json:"unit"`
+		}{s, json.Number(num), *to})
+		fmt.Fprintf(w, "%s\n", b)
+	}
+}
+
+// convertCSV copies the CSV file name to w, appending a column
+// "<column>_<unit>" that holds the converted values.
+func convertCSV(w io.Writer, name, column string) {
+	var in io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	header, err := r.Read()
+	if err != nil {
+		fatalf("reading %s: %v", name, err)
+	}
+	idx := -1
+	for i, h := range header {
+		if strings.TrimSpace(h) == column {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		fatalf("%s: no column named %q", name, column)
+	}
+	cw.Write(append(header, column+"_"+*to))
+
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Malformed quoting and the like; report it and move on,
+			// leaving an empty row so the output stays aligned with the
+			// input's records.
+			reportf("%s: %v", name, err)
+			cw.Write(make([]string, len(header)+1))
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		var out string
+		if idx >= len(rec) {
+			reportf("%s: line %d: missing column %q", name, line, column)
+		} else if cell := strings.TrimSpace(rec[idx]); cell != "" {
+			v, err := convert(cell)
+			if err != nil {
+				reportf("%s: line %d: %v", name, line, err)
+			} else {
+				out = formatValue(v)
+			}
+		}
+		cw.Write(append(rec, out))
+	}
+	if err := cw.Error(); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func reportf(format string, args ...interface{}) {
+	failed = true
+	fmt.Fprintf(os.Stderr, "cf: "+format+"\n", args...)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "cf: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -3,5 +3,35 @@ package tempconv
 // CToF converts a Celsius temperature to Fahrenheit.
 func CToF(c Celsius) Fahrenheit { return Fahrenheit(c*9/5 + 32) }
 
+// CToK converts a Celsius temperature to Kelvin.
+func CToK(c Celsius) Kelvin { return Kelvin(c - AbsoluteZeroC) }
+
+// CToR converts a Celsius temperature to Rankine.
+func CToR(c Celsius) Rankine { return Rankine((c - AbsoluteZeroC) * 9 / 5) }
+
 // FToC converts a Fahrenheit temperature to Celsius.
 func FToC(f Fahrenheit) Celsius { return Celsius((f - 32) * 5 / 9) }
+
+// FToK converts a Fahrenheit temperature to Kelvin.
+func FToK(f Fahrenheit) Kelvin { return CToK(FToC(f)) }
+
+// FToR converts a Fahrenheit temperature to Rankine.
+func FToR(f Fahrenheit) Rankine { return Rankine(f - AbsoluteZeroF) }
+
+// KToC converts a Kelvin temperature to Celsius.
+func KToC(k Kelvin) Celsius { return Celsius(k) + AbsoluteZeroC }
+
+// KToF converts a Kelvin temperature to Fahrenheit.
+func KToF(k Kelvin) Fahrenheit { return CToF(KToC(k)) }
+
+// KToR converts a Kelvin temperature to Rankine.
+func KToR(k Kelvin) Rankine { return Rankine(k * 9 / 5) }
+
+// RToC converts a Rankine temperature to Celsius.
+func RToC(r Rankine) Celsius { return Celsius(r*5/9) + AbsoluteZeroC }
+
+// RToF converts a Rankine temperature to Fahrenheit.
+func RToF(r Rankine) Fahrenheit { return Fahrenheit(r) + AbsoluteZeroF }
+
+// RToK converts a Rankine temperature to Kelvin.
+func RToK(r Rankine) Kelvin { return Kelvin(r * 5 / 9) }
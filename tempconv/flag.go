@@ -0,0 +1,25 @@
+package tempconv
+
+import "flag"
+
+// *celsiusFlag satisfies the flag.Value interface.
+type celsiusFlag struct{ Celsius }
+
+func (f *celsiusFlag) Set(s string) error {
+	c, err := ParseCelsius(s)
+	if err != nil {
+		return err
+	}
+	f.Celsius = c
+	return nil
+}
+
+// CelsiusFlag defines a Celsius flag with the specified name,
+// default value, and usage, and returns the address of the flag variable.
+// The flag argument must have a quantity and a unit, e.g., "100C",
+// "98.6F" or "310K"; it is normalized to Celsius.
+func CelsiusFlag(name string, value Celsius, usage string) *Celsius {
+	f := celsiusFlag{value}
+	flag.CommandLine.Var(&f, name, usage)
+	return &f.Celsius
+}
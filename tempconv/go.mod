@@ -1,2 +1,3 @@
 module tempconv
 
+go 1.19
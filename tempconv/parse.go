@@ -0,0 +1,143 @@
+package tempconv
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A scale identifies the unit suffix a temperature was written with.
+type scale byte
+
+const (
+	scaleC scale = 'C'
+	scaleF scale = 'F'
+	scaleK scale = 'K'
+	scaleR scale = 'R'
+)
+
+// suffixes maps every accepted unit suffix to its scale.
+// Longer suffixes come first so "°C" is not mistaken for "C".
+var suffixes = []struct {
+	suffix string
+	scale  scale
+}{
+	{"°C", scaleC}, {"°F", scaleF}, {"°R", scaleR},
+	{"C", scaleC}, {"F", scaleF}, {"K", scaleK}, {"R", scaleR},
+}
+
+// A quantity is a parsed temperature in the scale it was written in.
+type quantity struct {
+	v     float64
+	scale scale
+}
+
+func (q quantity) celsius() Celsius {
+	switch q.scale {
+	case scaleF:
+		return FToC(Fahrenheit(q.v))
+	case scaleK:
+		return KToC(Kelvin(q.v))
+	case scaleR:
+		return RToC(Rankine(q.v))
+	}
+	return Celsius(q.v)
+}
+
+func (q quantity) fahrenheit() Fahrenheit {
+	switch q.scale {
+	case scaleC:
+		return CToF(Celsius(q.v))
+	case scaleK:
+		return KToF(Kelvin(q.v))
+	case scaleR:
+		return RToF(Rankine(q.v))
+	}
+	return Fahrenheit(q.v)
+}
+
+func (q quantity) kelvin() Kelvin {
+	switch q.scale {
+	case scaleC:
+		return CToK(Celsius(q.v))
+	case scaleF:
+		return FToK(Fahrenheit(q.v))
+	case scaleR:
+		return RToK(Rankine(q.v))
+	}
+	return Kelvin(q.v)
+}
+
+func (q quantity) rankine() Rankine {
+	switch q.scale {
+	case scaleC:
+		return CToR(Celsius(q.v))
+	case scaleF:
+		return FToR(Fahrenheit(q.v))
+	case scaleK:
+		return KToR(Kelvin(q.v))
+	}
+	return Rankine(q.v)
+}
+
+// parse splits s into a quantity and a unit, e.g. "98.6F" or "37 °C".
+// The unit is case-insensitive and may be separated by spaces.
+// Temperatures below absolute zero, NaN and infinities are rejected.
+func parse(s string) (quantity, error) {
+	t := strings.TrimSpace(s)
+	upper := strings.ToUpper(t)
+	for _, u := range suffixes {
+		if !strings.HasSuffix(upper, u.suffix) {
+			continue
+		}
+		num := strings.TrimSpace(t[:len(t)-len(u.suffix)])
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return quantity{}, fmt.Errorf("invalid temperature %q: bad quantity %q", s, num)
+		}
+		q := quantity{v, u.scale}
+		if err := q.kelvin().Check(); err != nil {
+			return quantity{}, fmt.Errorf("invalid temperature %q: %w", s, ErrBelowAbsoluteZero)
+		}
+		return q, nil
+	}
+	return quantity{}, fmt.Errorf("invalid temperature %q: missing unit (C, F, K or R)", s)
+}
+
+// ParseCelsius parses a temperature in any supported unit, such as
+// "37C", "98.6°F" or "310K", and converts it to Celsius.
+func ParseCelsius(s string) (Celsius, error) {
+	q, err := parse(s)
+	if err != nil {
+		return 0, err
+	}
+	return q.celsius(), nil
+}
+
+// ParseFahrenheit is like ParseCelsius but converts to Fahrenheit.
+func ParseFahrenheit(s string) (Fahrenheit, error) {
+	q, err := parse(s)
+	if err != nil {
+		return 0, err
+	}
+	return q.fahrenheit(), nil
+}
+
+// ParseKelvin is like ParseCelsius but converts to Kelvin.
+func ParseKelvin(s string) (Kelvin, error) {
+	q, err := parse(s)
+	if err != nil {
+		return 0, err
+	}
+	return q.kelvin(), nil
+}
+
+// ParseRankine is like ParseCelsius but converts to Rankine.
+func ParseRankine(s string) (Rankine, error) {
+	q, err := parse(s)
+	if err != nil {
+		return 0, err
+	}
+	return q.rankine(), nil
+}
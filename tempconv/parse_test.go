@@ -0,0 +1,39 @@
+package tempconv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseCelsius(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Celsius
+	}{
+		{"37C", 37},
+		{"37 °C", 37},
+		{"98.6f", 37},
+		{"310.15K", 37},
+		{"558.27 °r", 37},
+		{"-459.67°F", AbsoluteZeroC},
+	}
+	for _, test := range tests {
+		got, err := ParseCelsius(test.in)
+		if err != nil || !near(float64(got), float64(test.want)) {
+			t.Errorf("ParseCelsius(%q) = %v, %v, want %v", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{"", "37", "C", "abcC", "NaNC", "nan K", "infK", "-InfF", "+Inf°R", "1e400C"} {
+		if got, err := ParseCelsius(in); err == nil {
+			t.Errorf("ParseCelsius(%q) = %v, want error", in, got)
+		}
+	}
+	for _, in := range []string{"-273.16C", "-1K", "-460F", "-0.5R"} {
+		if _, err := ParseCelsius(in); !errors.Is(err, ErrBelowAbsoluteZero) {
+			t.Errorf("ParseCelsius(%q) error = %v, want ErrBelowAbsoluteZero", in, err)
+		}
+	}
+}
@@ -1,16 +1,59 @@
-// Package tempconv performs Celsius and Fahrenheit conversions.
+// Package tempconv performs Celsius, Fahrenheit, Kelvin and Rankine conversions.
 package tempconv
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 type Celsius float64
 type Fahrenheit float64
+type Kelvin float64
+type Rankine float64
 
 const (
-	AbsoluteZeroC Celsius = -273.15
-	FreezingC     Celsius = 0
-	BoilingC      Celsius = 100
+	AbsoluteZeroC Celsius    = -273.15
+	AbsoluteZeroF Fahrenheit = -459.67
+	AbsoluteZeroK Kelvin     = 0
+	AbsoluteZeroR Rankine    = 0
+	FreezingC     Celsius    = 0
+	FreezingF     Fahrenheit = 32
+	FreezingK     Kelvin     = 273.15
+	FreezingR     Rankine    = 491.67
+	BoilingC      Celsius    = 100
+	BoilingF      Fahrenheit = 212
+	BoilingK      Kelvin     = 373.15
+	BoilingR      Rankine    = 671.67
 )
 
 func (c Celsius) String() string    { return fmt.Sprintf("%g°C", c) }
 func (f Fahrenheit) String() string { return fmt.Sprintf("%g°F", f) }
+func (k Kelvin) String() string     { return fmt.Sprintf("%gK", k) }
+func (r Rankine) String() string    { return fmt.Sprintf("%g°R", r) }
+
+// ErrBelowAbsoluteZero is returned by the Check methods for temperatures
+// colder than absolute zero.
+var ErrBelowAbsoluteZero = errors.New("temperature below absolute zero")
+
+// epsilonK absorbs the rounding error left by converting a value that
+// sits exactly on absolute zero in another scale.
+const epsilonK Kelvin = 1e-9
+
+func checkK(k Kelvin, t fmt.Stringer) error {
+	if k < AbsoluteZeroK-epsilonK {
+		return fmt.Errorf("%s: %w", t, ErrBelowAbsoluteZero)
+	}
+	return nil
+}
+
+// Check reports an error if c is below absolute zero.
+func (c Celsius) Check() error { return checkK(CToK(c), c) }
+
+// Check reports an error if f is below absolute zero.
+func (f Fahrenheit) Check() error { return checkK(FToK(f), f) }
+
+// Check reports an error if k is below absolute zero.
+func (k Kelvin) Check() error { return checkK(k, k) }
+
+// Check reports an error if r is below absolute zero.
+func (r Rankine) Check() error { return checkK(RToK(r), r) }
@@ -0,0 +1,109 @@
+package tempconv
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// A point is one temperature written in all four scales.
+type point struct {
+	name string
+	c    Celsius
+	f    Fahrenheit
+	k    Kelvin
+	r    Rankine
+}
+
+var points = []point{
+	{"absolute zero", -273.15, -459.67, 0, 0},
+	{"minus forty", -40, -40, 233.15, 419.67},
+	{"freezing", 0, 32, 273.15, 491.67},
+	{"body", 37, 98.6, 310.15, 558.27},
+	{"boiling", 100, 212, 373.15, 671.67},
+	{"sun surface", 5505, 9941, 5778.15, 10400.67},
+}
+
+func near(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+}
+
+func TestConversions(t *testing.T) {
+	for _, p := range points {
+		tests := []struct {
+			fn        string
+			got, want float64
+		}{
+			{"CToF", float64(CToF(p.c)), float64(p.f)},
+			{"CToK", float64(CToK(p.c)), float64(p.k)},
+			{"CToR", float64(CToR(p.c)), float64(p.r)},
+			{"FToC", float64(FToC(p.f)), float64(p.c)},
+			{"FToK", float64(FToK(p.f)), float64(p.k)},
+			{"FToR", float64(FToR(p.f)), float64(p.r)},
+			{"KToC", float64(KToC(p.k)), float64(p.c)},
+			{"KToF", float64(KToF(p.k)), float64(p.f)},
+			{"KToR", float64(KToR(p.k)), float64(p.r)},
+			{"RToC", float64(RToC(p.r)), float64(p.c)},
+			{"RToF", float64(RToF(p.r)), float64(p.f)},
+			{"RToK", float64(RToK(p.r)), float64(p.k)},
+		}
+		for _, test := range tests {
+			if !near(test.got, test.want) {
+				t.Errorf("%s: %s = %g, want %g", p.name, test.fn, test.got, test.want)
+			}
+		}
+	}
+}
+
+func TestConstants(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Celsius
+		f    Fahrenheit
+		k    Kelvin
+		r    Rankine
+	}{
+		{"AbsoluteZero", AbsoluteZeroC, AbsoluteZeroF, AbsoluteZeroK, AbsoluteZeroR},
+		{"Freezing", FreezingC, FreezingF, FreezingK, FreezingR},
+		{"Boiling", BoilingC, BoilingF, BoilingK, BoilingR},
+	}
+	for _, test := range tests {
+		if got := CToF(test.c); !near(float64(got), float64(test.f)) {
+			t.Errorf("CToF(%sC) = %v, want %sF = %v", test.name, got, test.name, test.f)
+		}
+		if got := CToK(test.c); !near(float64(got), float64(test.k)) {
+			t.Errorf("CToK(%sC) = %v, want %sK = %v", test.name, got, test.name, test.k)
+		}
+		if got := CToR(test.c); !near(float64(got), float64(test.r)) {
+			t.Errorf("CToR(%sC) = %v, want %sR = %v", test.name, got, test.name, test.r)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		t    interface{ Check() error }
+		fail bool
+	}{
+		{AbsoluteZeroC, false},
+		{AbsoluteZeroF, false},
+		{AbsoluteZeroK, false},
+		{AbsoluteZeroR, false},
+		{KToC(AbsoluteZeroK), false}, // rounding must not push it below zero
+		{RToF(AbsoluteZeroR), false},
+		{BoilingC, false},
+		{Celsius(-273.16), true},
+		{Fahrenheit(-460), true},
+		{Kelvin(-0.001), true},
+		{Rankine(-1), true},
+	}
+	for _, test := range tests {
+		err := test.t.Check()
+		if (err != nil) != test.fail {
+			t.Errorf("%v.Check() = %v, want failure %t", test.t, err, test.fail)
+		}
+		if err != nil && !errors.Is(err, ErrBelowAbsoluteZero) {
+			t.Errorf("%v.Check() = %v, want ErrBelowAbsoluteZero", test.t, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tempconv
+
+// The temperature types implement encoding.TextMarshaler and
+// encoding.TextUnmarshaler, so encoding/json and friends carry the unit
+// along as a string such as "21.5°C" instead of a bare number.
+// Unmarshaling accepts any supported unit and converts it to the target
+// type, so a Celsius field may be filled from "70.7°F". Marshaling
+// fails for temperatures that unmarshaling would reject, those below
+// absolute zero and those that are not finite, so that whatever is
+// written can be read back.
+
+import (
+	"fmt"
+	"math"
+)
+
+// marshalText renders t, whose value in Kelvin is k.
+func marshalText(t fmt.Stringer, k Kelvin) ([]byte, error) {
+	if math.IsNaN(float64(k)) || math.IsInf(float64(k), 0) {
+		return nil, fmt.Errorf("%s: temperature is not finite", t)
+	}
+	if err := checkK(k, t); err != nil {
+		return nil, err
+	}
+	return []byte(t.String()), nil
+}
+
+func (c Celsius) MarshalText() ([]byte, error) { return marshalText(c, CToK(c)) }
+
+func (c *Celsius) UnmarshalText(text []byte) error {
+	v, err := ParseCelsius(string(text))
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+func (f Fahrenheit) MarshalText() ([]byte, error) { return marshalText(f, FToK(f)) }
+
+func (f *Fahrenheit) UnmarshalText(text []byte) error {
+	v, err := ParseFahrenheit(string(text))
+	if err != nil {
+		return err
+	}
+	*f = v
+	return nil
+}
+
+func (k Kelvin) MarshalText() ([]byte, error) { return marshalText(k, k) }
+
+func (k *Kelvin) UnmarshalText(text []byte) error {
+	v, err := ParseKelvin(string(text))
+	if err != nil {
+		return err
+	}
+	*k = v
+	return nil
+}
+
+func (r Rankine) MarshalText() ([]byte, error) { return marshalText(r, RToK(r)) }
+
+func (r *Rankine) UnmarshalText(text []byte) error {
+	v, err := ParseRankine(string(text))
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
@@ -0,0 +1,101 @@
+package tempconv
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+// roundTrip checks that in, whose value is v, either marshals to text
+// that unmarshals into out as v again, or is refused because Check or
+// finiteness rule it out.
+func roundTrip(t *testing.T, v float64, in interface {
+	encoding.TextMarshaler
+	Check() error
+}, out encoding.TextUnmarshaler, got func() float64) {
+	text, err := in.MarshalText()
+	valid := !math.IsNaN(v) && !math.IsInf(v, 0) && in.Check() == nil
+	if err != nil {
+		if valid {
+			t.Fatalf("MarshalText(%v) failed: %v", v, err)
+		}
+		return
+	}
+	if !valid {
+		t.Fatalf("MarshalText(%v) = %q, want error", v, text)
+	}
+	if err := out.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText(%q) failed: %v", text, err)
+	}
+	if got() != v {
+		t.Fatalf("UnmarshalText(%q) = %v, want %v", text, got(), v)
+	}
+}
+
+var fuzzSeeds = []float64{0, -0.0, 21.5, -40, -273.15, -459.67, 1e300, -1, math.NaN(), math.Inf(1)}
+
+func FuzzTextRoundTripCelsius(f *testing.F) {
+	for _, v := range fuzzSeeds {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v float64) {
+		var out Celsius
+		roundTrip(t, v, Celsius(v), &out, func() float64 { return float64(out) })
+	})
+}
+
+func FuzzTextRoundTripFahrenheit(f *testing.F) {
+	for _, v := range fuzzSeeds {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v float64) {
+		var out Fahrenheit
+		roundTrip(t, v, Fahrenheit(v), &out, func() float64 { return float64(out) })
+	})
+}
+
+func FuzzTextRoundTripKelvin(f *testing.F) {
+	for _, v := range fuzzSeeds {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v float64) {
+		var out Kelvin
+		roundTrip(t, v, Kelvin(v), &out, func() float64 { return float64(out) })
+	})
+}
+
+func FuzzTextRoundTripRankine(f *testing.F) {
+	for _, v := range fuzzSeeds {
+		f.Add(v)
+	}
+	f.Fuzz(func(t *testing.T, v float64) {
+		var out Rankine
+		roundTrip(t, v, Rankine(v), &out, func() float64 { return float64(out) })
+	})
+}
+
+func TestJSON(t *testing.T) {
+	type reading struct {
+		Indoor  Celsius    `json:"indoor"`
+		Outdoor Fahrenheit `json:"outdoor"`
+	}
+	b, err := json.Marshal(reading{21.5, 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"indoor":"21.5°C","outdoor":"14°F"}`; string(b) != want {
+		t.Errorf("json.Marshal = %s, want %s", b, want)
+	}
+	var r reading
+	if err := json.Unmarshal([]byte(`{"indoor":"294.65K","outdoor":"-10°C"}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if !near(float64(r.Indoor), 21.5) || !near(float64(r.Outdoor), 14) {
+		t.Errorf("json.Unmarshal = %+v, want {21.5 14}", r)
+	}
+	if _, err := json.Marshal(Celsius(-300)); !errors.Is(err, ErrBelowAbsoluteZero) {
+		t.Errorf("json.Marshal(-300°C) error = %v, want ErrBelowAbsoluteZero", err)
+	}
+}
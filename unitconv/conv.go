@@ -0,0 +1,31 @@
+package unitconv
+
+// MToFt converts a length in meters to feet.
+func MToFt(m Meter) Foot { return Foot(m / MetersPerFoot) }
+
+// FtToM converts a length in feet to meters.
+func FtToM(f Foot) Meter { return Meter(f * MetersPerFoot) }
+
+// KgToLb converts a mass in kilograms to pounds.
+func KgToLb(k Kilogram) Pound { return Pound(k / KilogramsPerPound) }
+
+// LbToKg converts a mass in pounds to kilograms.
+func LbToKg(p Pound) Kilogram { return Kilogram(p * KilogramsPerPound) }
+
+// PaToPSI converts a pressure in pascals to pounds per square inch.
+func PaToPSI(p Pascal) PSI { return PSI(p / PascalsPerPSI) }
+
+// PaToBar converts a pressure in pascals to bars.
+func PaToBar(p Pascal) Bar { return Bar(p / PascalsPerBar) }
+
+// PSIToPa converts a pressure in pounds per square inch to pascals.
+func PSIToPa(p PSI) Pascal { return Pascal(p * PascalsPerPSI) }
+
+// PSIToBar converts a pressure in pounds per square inch to bars.
+func PSIToBar(p PSI) Bar { return PaToBar(PSIToPa(p)) }
+
+// BarToPa converts a pressure in bars to pascals.
+func BarToPa(b Bar) Pascal { return Pascal(b * PascalsPerBar) }
+
+// BarToPSI converts a pressure in bars to pounds per square inch.
+func BarToPSI(b Bar) PSI { return PaToPSI(BarToPa(b)) }
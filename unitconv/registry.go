@@ -0,0 +1,118 @@
+package unitconv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A Dimension is the physical quantity a unit measures.
+// Only units of the same dimension can be converted into each other.
+type Dimension string
+
+const (
+	Length   Dimension = "length"
+	Mass     Dimension = "mass"
+	Pressure Dimension = "pressure"
+)
+
+// A Unit describes how to convert values to and from the base unit of
+// its dimension (meter, kilogram, pascal).
+type Unit struct {
+	Name      string   // canonical name, e.g. "ft"
+	Aliases   []string // other accepted names, e.g. "foot", "feet"
+	Dimension Dimension
+	ToBase    func(float64) float64
+	FromBase  func(float64) float64
+}
+
+var (
+	ErrUnknownUnit       = errors.New("unknown unit")
+	ErrDimensionMismatch = errors.New("dimension mismatch")
+)
+
+var (
+	mu    sync.RWMutex
+	units = make(map[string]*Unit) // keyed by lower-case name and alias
+)
+
+// Register adds u to the registry under its name and aliases.
+// Names are case-insensitive and must not already be taken.
+func Register(u Unit) error {
+	if u.Name == "" || u.Dimension == "" || u.ToBase == nil || u.FromBase == nil {
+		return fmt.Errorf("register %q: incomplete unit", u.Name)
+	}
+	names := append([]string{u.Name}, u.Aliases...)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, n := range names {
+		if _, ok := units[strings.ToLower(n)]; ok {
+			return fmt.Errorf("register %q: name %q already registered", u.Name, n)
+		}
+	}
+	p := &u
+	for _, n := range names {
+		units[strings.ToLower(n)] = p
+	}
+	return nil
+}
+
+// Lookup returns the unit registered under name.
+func Lookup(name string) (Unit, error) {
+	mu.RLock()
+	u, ok := units[strings.ToLower(strings.TrimSpace(name))]
+	mu.RUnlock()
+	if !ok {
+		return Unit{}, fmt.Errorf("%q: %w", name, ErrUnknownUnit)
+	}
+	return *u, nil
+}
+
+// Convert converts value from the unit named from to the unit named to,
+// e.g. Convert(3, "ft", "m"). Both units must measure the same dimension.
+func Convert(value float64, from, to string) (float64, error) {
+	f, err := Lookup(from)
+	if err != nil {
+		return 0, err
+	}
+	t, err := Lookup(to)
+	if err != nil {
+		return 0, err
+	}
+	if f.Dimension != t.Dimension {
+		return 0, fmt.Errorf("convert %s (%s) to %s (%s): %w",
+			f.Name, f.Dimension, t.Name, t.Dimension, ErrDimensionMismatch)
+	}
+	if f.Name == t.Name {
+		return value, nil
+	}
+	return t.FromBase(f.ToBase(value)), nil
+}
+
+// linear returns a Unit whose base value is value*factor.
+func linear(name string, dim Dimension, factor float64, aliases ...string) Unit {
+	return Unit{
+		Name:      name,
+		Aliases:   aliases,
+		Dimension: dim,
+		ToBase:    func(v float64) float64 { return v * factor },
+		FromBase:  func(v float64) float64 { return v / factor },
+	}
+}
+
+func init() {
+	for _, u := range []Unit{
+		linear("m", Length, 1, "meter", "meters", "metre", "metres"),
+		linear("ft", Length, MetersPerFoot, "foot", "feet"),
+		linear("kg", Mass, 1, "kilogram", "kilograms"),
+		linear("lb", Mass, KilogramsPerPound, "lbs", "pound", "pounds"),
+		linear("Pa", Pressure, 1, "pascal", "pascals"),
+		linear("psi", Pressure, PascalsPerPSI),
+		linear("bar", Pressure, PascalsPerBar, "bars"),
+	} {
+		if err := Register(u); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Package unitconv performs length, mass and pressure conversions
+// in the style of tempconv, and looks units up by name at run time.
+package unitconv
+
+import "fmt"
+
+type Meter float64
+type Foot float64
+
+type Kilogram float64
+type Pound float64
+
+type Pascal float64
+type PSI float64
+type Bar float64
+
+const (
+	MetersPerFoot     = 0.3048
+	KilogramsPerPound = 0.45359237
+	PascalsPerPSI     = 6894.757293168361
+	PascalsPerBar     = 100000
+)
+
+func (m Meter) String() string    { return fmt.Sprintf("%gm", m) }
+func (f Foot) String() string     { return fmt.Sprintf("%gft", f) }
+func (k Kilogram) String() string { return fmt.Sprintf("%gkg", k) }
+func (p Pound) String() string    { return fmt.Sprintf("%glb", p) }
+func (p Pascal) String() string   { return fmt.Sprintf("%gPa", p) }
+func (p PSI) String() string      { return fmt.Sprintf("%gpsi", p) }
+func (b Bar) String() string      { return fmt.Sprintf("%gbar", b) }